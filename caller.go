@@ -0,0 +1,54 @@
+package otelxorm
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.18.0"
+	"runtime"
+	"strings"
+)
+
+const (
+	packagePath = "github.com/neonyo/otelxorm"
+	// maxCallerDepth bounds the stack walk of WithRecordAppCaller.
+	maxCallerDepth = 32
+)
+
+// appCallerAttributes returns the code.* attributes of the first frame of the
+// calling goroutine outside xorm and this package, or nil if none is found
+// within maxCallerDepth frames.
+func appCallerAttributes() []attribute.KeyValue {
+	pcs := make([]uintptr, maxCallerDepth)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" && !isInstrumentationFrame(frame.Function) {
+			namespace, function := splitFunctionName(frame.Function)
+			return []attribute.KeyValue{
+				semconv.CodeNamespace(namespace),
+				semconv.CodeFunction(function),
+				semconv.CodeFilepath(frame.File),
+				semconv.CodeLineNumber(frame.Line),
+			}
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+func isInstrumentationFrame(function string) bool {
+	return strings.HasPrefix(function, "xorm.io/") ||
+		strings.HasPrefix(function, packagePath+".") ||
+		strings.HasPrefix(function, "database/sql.") ||
+		strings.HasPrefix(function, "runtime.")
+}
+
+// splitFunctionName splits a qualified function name such as
+// "example.com/app.(*Repo).Find" into "example.com/app.(*Repo)" and "Find".
+func splitFunctionName(name string) (namespace, function string) {
+	if i := strings.LastIndexByte(name, '.'); i > strings.LastIndexByte(name, '/') {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
@@ -0,0 +1,106 @@
+package otelxorm_test
+
+import (
+	"context"
+	"github.com/neonyo/otelxorm"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.18.0"
+	"path/filepath"
+	"testing"
+	"xorm.io/xorm/contexts"
+)
+
+// findUser runs a query through h the way xorm does, standing in for an
+// application call site.
+func findUser(h contexts.Hook) error {
+	c := contexts.NewContextHook(context.Background(), "SELECT * FROM users WHERE id = ?", []interface{}{1})
+	ctx, err := h.BeforeProcess(c)
+	if err != nil {
+		return err
+	}
+	c.Ctx = ctx
+	return h.AfterProcess(c)
+}
+
+type userRepo struct {
+	hook contexts.Hook
+}
+
+func (r *userRepo) Find() error {
+	c := contexts.NewContextHook(context.Background(), "SELECT * FROM users", nil)
+	ctx, err := r.hook.BeforeProcess(c)
+	if err != nil {
+		return err
+	}
+	c.Ctx = ctx
+	return r.hook.AfterProcess(c)
+}
+
+func TestRecordAppCaller(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []otelxorm.Option
+		run           func(h contexts.Hook) error
+		wantNamespace string
+		wantFunction  string
+	}{
+		{
+			name:          "function",
+			opts:          []otelxorm.Option{otelxorm.WithRecordAppCaller()},
+			run:           findUser,
+			wantNamespace: "github.com/neonyo/otelxorm_test",
+			wantFunction:  "findUser",
+		},
+		{
+			name:          "method",
+			opts:          []otelxorm.Option{otelxorm.WithRecordAppCaller()},
+			run:           func(h contexts.Hook) error { return (&userRepo{hook: h}).Find() },
+			wantNamespace: "github.com/neonyo/otelxorm_test.(*userRepo)",
+			wantFunction:  "Find",
+		},
+		{
+			name: "disabled",
+			run:  findUser,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := tracetest.NewInMemoryExporter()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+			h := otelxorm.Hook(append([]otelxorm.Option{otelxorm.WithTracerProvider(tp)}, tt.opts...)...)
+			if err := tt.run(h); err != nil {
+				t.Fatal(err)
+			}
+			spans := exp.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			attrs := map[attribute.Key]attribute.Value{}
+			for _, kv := range spans[0].Attributes {
+				attrs[kv.Key] = kv.Value
+			}
+			if tt.wantFunction == "" {
+				for _, key := range []attribute.Key{semconv.CodeNamespaceKey, semconv.CodeFunctionKey, semconv.CodeFilepathKey, semconv.CodeLineNumberKey} {
+					if v, ok := attrs[key]; ok {
+						t.Errorf("%s = %q, want it absent", key, v.Emit())
+					}
+				}
+				return
+			}
+			if got := attrs[semconv.CodeNamespaceKey].AsString(); got != tt.wantNamespace {
+				t.Errorf("code.namespace = %q, want %q", got, tt.wantNamespace)
+			}
+			if got := attrs[semconv.CodeFunctionKey].AsString(); got != tt.wantFunction {
+				t.Errorf("code.function = %q, want %q", got, tt.wantFunction)
+			}
+			if got := filepath.Base(attrs[semconv.CodeFilepathKey].AsString()); got != "caller_test.go" {
+				t.Errorf("code.filepath = %q, want the test file", attrs[semconv.CodeFilepathKey].AsString())
+			}
+			if attrs[semconv.CodeLineNumberKey].AsInt64() <= 0 {
+				t.Errorf("code.lineno = %d, want a line", attrs[semconv.CodeLineNumberKey].AsInt64())
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package otelxorm
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"sync/atomic"
+)
+
+// Context keys are unexported struct types, so they can't collide with the
+// keys of other packages. The span itself is carried with trace.ContextWithSpan
+// from BeforeProcess to AfterProcess.
+type (
+	accumulatorKey   struct{}
+	preparedScopeKey struct{}
+	forceSampleKey   struct{}
+	lazySpanKey      struct{}
+	siblingScopeKey  struct{}
+	renameSpanKey    struct{}
+	heartbeatKey     struct{}
+	filteredKey      struct{}
+	filterPendingKey struct{}
+)
+
+// legacySpanCtxKey is the string key under which, without WithContextKey, the
+// parent context is looked up when the query context carries no span.
+//
+// Deprecated: pass the traced context to the xorm session, or store it under
+// a typed key given to WithContextKey. The fallback will be removed in a
+// future version.
+const legacySpanCtxKey = "spanCtx"
+
+// legacyParentContext returns the context.Context stored in ctx under
+// legacySpanCtxKey, if ctx carries no span of its own.
+func legacyParentContext(ctx context.Context) (context.Context, bool) {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return nil, false
+	}
+	parent, ok := ctx.Value(legacySpanCtxKey).(context.Context)
+	return parent, ok
+}
+
+// lazySpan holds what is needed to start a span in AfterProcess when
+// WithLazySpanNaming is enabled.
+type lazySpan struct {
+	parent context.Context
+	name   string
+	// rename reports whether name may be replaced by the statement name.
+	rename bool
+	// filter reports whether WithFilter must still be consulted, the SQL
+	// being unknown in BeforeProcess.
+	filter bool
+	opts   []trace.SpanStartOption
+}
+
+// accumulatorsUsed and forceSampleUsed are set to 1 the first time
+// WithAttributeAccumulator and ForceSample are called. These features are not
+// enabled by an option, so the hook skips their context lookups until then.
+var accumulatorsUsed, forceSampleUsed int32
+
+type attributeAccumulator struct {
+	mu    sync.Mutex
+	attrs []attribute.KeyValue
+}
+
+// WithAttributeAccumulator returns a copy of ctx carrying an attribute
+// collector. Attributes added with AddQueryAttribute are attached to the
+// next span completed with this context.
+func WithAttributeAccumulator(ctx context.Context) context.Context {
+	atomic.StoreInt32(&accumulatorsUsed, 1)
+	return context.WithValue(ctx, accumulatorKey{}, &attributeAccumulator{})
+}
+
+// AddQueryAttribute adds attributes to the collector carried by ctx. It is a
+// no-op if ctx has no collector. It is safe for concurrent use.
+func AddQueryAttribute(ctx context.Context, kv ...attribute.KeyValue) {
+	acc, ok := ctx.Value(accumulatorKey{}).(*attributeAccumulator)
+	if !ok {
+		return
+	}
+	acc.mu.Lock()
+	acc.attrs = append(acc.attrs, kv...)
+	acc.mu.Unlock()
+}
+
+// drainQueryAttributes returns and clears the attributes collected in ctx.
+func drainQueryAttributes(ctx context.Context) []attribute.KeyValue {
+	if atomic.LoadInt32(&accumulatorsUsed) == 0 {
+		return nil
+	}
+	acc, ok := ctx.Value(accumulatorKey{}).(*attributeAccumulator)
+	if !ok {
+		return nil
+	}
+	acc.mu.Lock()
+	attrs := acc.attrs
+	acc.attrs = nil
+	acc.mu.Unlock()
+	return attrs
+}
+
+type preparedScope struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// WithPreparedReuseScope returns a copy of ctx carrying a statement
+// execution counter, typically one per xorm session. With
+// WithRecordPreparedReuse, each span records in db.prepared.reuse how many
+// times the same statement already ran within the scope.
+func WithPreparedReuseScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, preparedScopeKey{}, &preparedScope{counts: make(map[string]int64)})
+}
+
+// preparedReuse increments the execution count of the statement identified
+// by fp and returns the number of earlier executions.
+func preparedReuse(ctx context.Context, fp string) (int64, bool) {
+	scope, ok := ctx.Value(preparedScopeKey{}).(*preparedScope)
+	if !ok {
+		return 0, false
+	}
+	scope.mu.Lock()
+	n := scope.counts[fp]
+	scope.counts[fp] = n + 1
+	scope.mu.Unlock()
+	return n, true
+}
+
+// ForceSample returns a copy of ctx asking for the database spans started with
+// it to be kept. The span is started with a sampling.priority=1 attribute:
+// head samplers see it in their SamplingParameters and tail samplers see it
+// on the exported span. The default parent-based samplers ignore it, so a
+// query under an unsampled parent still needs a sampler that honours the
+// attribute.
+func ForceSample(ctx context.Context) context.Context {
+	atomic.StoreInt32(&forceSampleUsed, 1)
+	return context.WithValue(ctx, forceSampleKey{}, true)
+}
+
+func isForceSampled(ctx context.Context) bool {
+	if atomic.LoadInt32(&forceSampleUsed) == 0 {
+		return false
+	}
+	forced, _ := ctx.Value(forceSampleKey{}).(bool)
+	return forced
+}
+
+// siblingScope counts the database spans started under each parent span.
+type siblingScope struct {
+	counts sync.Map // trace.SpanID -> *int64
+}
+
+// WithSiblingScope returns a copy of ctx in which WithRecordSiblingIndex
+// numbers the database queries run under the same parent span. Call it
+// where the business operation starts; a high index under one parent often
+// reveals an N+1 query loop.
+func WithSiblingScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, siblingScopeKey{}, &siblingScope{})
+}
+
+// siblingIndex returns the 1-based position of a new query among the queries
+// run under the parent span of parentCtx, in the scope of ctx.
+func siblingIndex(ctx, parentCtx context.Context) (int64, bool) {
+	scope, ok := ctx.Value(siblingScopeKey{}).(*siblingScope)
+	if !ok {
+		return 0, false
+	}
+	parent := trace.SpanContextFromContext(parentCtx).SpanID()
+	counter, _ := scope.counts.LoadOrStore(parent, new(int64))
+	return atomic.AddInt64(counter.(*int64), 1), true
+}
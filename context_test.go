@@ -0,0 +1,316 @@
+package otelxorm
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.18.0"
+	"go.opentelemetry.io/otel/trace"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"xorm.io/xorm/contexts"
+)
+
+func TestAttributeAccumulator(t *testing.T) {
+	h, exp := newTestHook()
+	ctx := WithAttributeAccumulator(context.Background())
+	AddQueryAttribute(ctx, attribute.String("app.endpoint", "/users"), attribute.Int("app.page", 2))
+	testQuery{ctx: ctx, sql: "SELECT * FROM users"}.run(t, h)
+	testQuery{ctx: ctx, sql: "SELECT * FROM orders"}.run(t, h)
+
+	spans := exp.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if v, ok := attrValue(spans[0].Attributes, "app.endpoint"); !ok || v.AsString() != "/users" {
+		t.Errorf("app.endpoint = %q, want /users", v.Emit())
+	}
+	if v, ok := attrValue(spans[0].Attributes, "app.page"); !ok || v.AsInt64() != 2 {
+		t.Errorf("app.page = %q, want 2", v.Emit())
+	}
+	if _, ok := attrValue(spans[1].Attributes, "app.endpoint"); ok {
+		t.Error("attributes were not drained by the first query")
+	}
+}
+
+func TestAttributeAccumulatorConcurrent(t *testing.T) {
+	ctx := WithAttributeAccumulator(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			AddQueryAttribute(ctx, attribute.Int("n", i))
+		}(i)
+	}
+	wg.Wait()
+	if got := len(drainQueryAttributes(ctx)); got != 50 {
+		t.Errorf("got %d attributes, want 50", got)
+	}
+}
+
+func TestAddQueryAttributeWithoutAccumulator(t *testing.T) {
+	ctx := context.Background()
+	AddQueryAttribute(ctx, attribute.String("k", "v"))
+	if attrs := drainQueryAttributes(ctx); attrs != nil {
+		t.Errorf("got %v, want no attributes", attrs)
+	}
+}
+
+func TestPreparedReuse(t *testing.T) {
+	h, exp := newTestHook(WithRecordPreparedReuse())
+	ctx := WithPreparedReuseScope(context.Background())
+	for _, sql := range []string{
+		"SELECT * FROM users WHERE id = ?",
+		"SELECT * FROM users WHERE id = ?",
+		"SELECT *  FROM users\n WHERE id = ?",
+		"SELECT * FROM orders WHERE id = ?",
+	} {
+		testQuery{ctx: ctx, sql: sql, args: []interface{}{1}}.run(t, h)
+	}
+	testQuery{sql: "SELECT * FROM users WHERE id = ?"}.run(t, h)
+
+	spans := exp.GetSpans()
+	for i, want := range []int64{0, 1, 2, 0} {
+		if v, ok := attrValue(spans[i].Attributes, "db.prepared.reuse"); !ok || v.AsInt64() != want {
+			t.Errorf("query %d: db.prepared.reuse = %q, want %d", i, v.Emit(), want)
+		}
+	}
+	if _, ok := attrValue(spans[4].Attributes, "db.prepared.reuse"); ok {
+		t.Error("db.prepared.reuse is set without a scope")
+	}
+}
+
+func TestPreparedReuseScopesAreIndependent(t *testing.T) {
+	h, exp := newTestHook(WithRecordPreparedReuse())
+	first := WithPreparedReuseScope(context.Background())
+	second := WithPreparedReuseScope(context.Background())
+	testQuery{ctx: first, sql: "SELECT 1"}.run(t, h)
+	testQuery{ctx: first, sql: "SELECT 1"}.run(t, h)
+	testQuery{ctx: second, sql: "SELECT 1"}.run(t, h)
+
+	spans := exp.GetSpans()
+	if v, _ := attrValue(spans[2].Attributes, "db.prepared.reuse"); v.AsInt64() != 0 {
+		t.Errorf("db.prepared.reuse = %d in a new scope, want 0", v.AsInt64())
+	}
+}
+
+// prioritySampler samples only spans started with sampling.priority=1.
+type prioritySampler struct{}
+
+func (prioritySampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	for _, attr := range p.Attributes {
+		if attr.Key == "sampling.priority" && attr.Value.AsInt64() == 1 {
+			return sdktrace.SamplingResult{Decision: sdktrace.RecordAndSample}
+		}
+	}
+	return sdktrace.SamplingResult{Decision: sdktrace.Drop}
+}
+
+func (prioritySampler) Description() string { return "prioritySampler" }
+
+func TestForceSample(t *testing.T) {
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		forced bool
+	}{
+		{"forced", ForceSample(context.Background()), true},
+		{"not forced", context.Background(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{ctx: tt.ctx, sql: "SELECT * FROM users"})
+			v, ok := attrValue(span.Attributes, "sampling.priority")
+			if ok != tt.forced || (ok && v.AsInt64() != 1) {
+				t.Errorf("sampling.priority = %q (present %v), want present %v", v.Emit(), ok, tt.forced)
+			}
+		})
+	}
+}
+
+func TestForceSampleVisibleToHeadSampler(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp), sdktrace.WithSampler(prioritySampler{}))
+	h := Hook(WithTracerProvider(tp))
+
+	testQuery{sql: "SELECT * FROM users"}.run(t, h)
+	testQuery{ctx: ForceSample(context.Background()), sql: "SELECT * FROM orders"}.run(t, h)
+
+	spans := exp.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if v, _ := attrValue(spans[0].Attributes, semconv.DBStatementKey); !strings.HasPrefix(v.AsString(), "SELECT * FROM orders") {
+		t.Errorf("sampled %q, want the forced query", v.AsString())
+	}
+}
+
+func TestSiblingIndex(t *testing.T) {
+	h, exp := newTestHook(WithRecordSiblingIndex())
+	scope := WithSiblingScope(context.Background())
+	tracer := h.config.tracerProvider.Tracer("test")
+	first, firstSpan := tracer.Start(scope, "first")
+	second, secondSpan := tracer.Start(scope, "second")
+
+	for i := 0; i < 3; i++ {
+		testQuery{ctx: first, sql: "SELECT * FROM orders WHERE user_id = ?", args: []interface{}{i}}.run(t, h)
+	}
+	testQuery{ctx: second, sql: "SELECT * FROM users"}.run(t, h)
+	testQuery{ctx: context.Background(), sql: "SELECT 1"}.run(t, h)
+	firstSpan.End()
+	secondSpan.End()
+
+	indexes := map[string][]int64{}
+	for _, span := range exp.GetSpans() {
+		if span.Name == "first" || span.Name == "second" {
+			continue
+		}
+		v, ok := attrValue(span.Attributes, "db.sibling_query.index")
+		parent := "none"
+		switch span.Parent.SpanID() {
+		case firstSpan.SpanContext().SpanID():
+			parent = "first"
+		case secondSpan.SpanContext().SpanID():
+			parent = "second"
+		}
+		if ok {
+			indexes[parent] = append(indexes[parent], v.AsInt64())
+		} else {
+			indexes[parent] = append(indexes[parent], 0)
+		}
+	}
+	if got := indexes["first"]; !reflect.DeepEqual(got, []int64{1, 2, 3}) {
+		t.Errorf("indexes under the first parent = %v, want [1 2 3]", got)
+	}
+	if got := indexes["second"]; !reflect.DeepEqual(got, []int64{1}) {
+		t.Errorf("indexes under the second parent = %v, want [1]", got)
+	}
+	if got := indexes["none"]; !reflect.DeepEqual(got, []int64{0}) {
+		t.Errorf("index outside a sibling scope = %v, want none", got)
+	}
+}
+
+func TestSiblingIndexConcurrent(t *testing.T) {
+	h, exp := newTestHook(WithRecordSiblingIndex())
+	parent, span := h.config.tracerProvider.Tracer("test").Start(WithSiblingScope(context.Background()), "parent")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testQuery{ctx: parent, sql: "SELECT 1"}.run(t, h)
+		}()
+	}
+	wg.Wait()
+	span.End()
+
+	seen := make(map[int64]bool)
+	for _, s := range exp.GetSpans() {
+		if v, ok := attrValue(s.Attributes, "db.sibling_query.index"); ok {
+			seen[v.AsInt64()] = true
+		}
+	}
+	for i := int64(1); i <= 20; i++ {
+		if !seen[i] {
+			t.Errorf("index %d not assigned", i)
+		}
+	}
+}
+
+// keyRecordingContext records the keys of the values looked up in it.
+type keyRecordingContext struct {
+	context.Context
+	mu   sync.Mutex
+	keys []interface{}
+}
+
+func (c *keyRecordingContext) Value(key interface{}) interface{} {
+	c.mu.Lock()
+	c.keys = append(c.keys, key)
+	c.mu.Unlock()
+	return c.Context.Value(key)
+}
+
+func (c *keyRecordingContext) read(key interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range c.keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSpanThreadedThroughContext(t *testing.T) {
+	type spanCtxKey struct{}
+	tests := []struct {
+		name string
+		opts []Option
+		// querySpan puts the parent span in the query context itself.
+		querySpan      bool
+		wantParent     string
+		wantLegacyRead bool
+	}{
+		{"span in the query context", nil, true, "parent", false},
+		{"legacy spanCtx key", nil, false, "legacy", true},
+		{"legacy key ignored with WithContextKey", []Option{WithContextKey(spanCtxKey{})}, false, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, exp := newTestHook(tt.opts...)
+			tracer := h.config.tracerProvider.Tracer("test")
+			ctx, parent := tracer.Start(context.Background(), "parent")
+			if !tt.querySpan {
+				ctx = context.Background()
+			}
+			legacyCtx, legacy := tracer.Start(context.Background(), "legacy")
+			_, otherSpan := tracer.Start(context.Background(), "other")
+			ctx = context.WithValue(ctx, "span", otherSpan)
+			ctx = context.WithValue(ctx, "spanCtx", legacyCtx)
+			recording := &keyRecordingContext{Context: ctx}
+
+			c := contexts.NewContextHook(recording, "SELECT 1", nil)
+			queryCtx, err := h.BeforeProcess(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			started := trace.SpanFromContext(queryCtx).SpanContext()
+			c.End(queryCtx, nil, nil)
+			if err := h.AfterProcess(c); err != nil {
+				t.Fatal(err)
+			}
+			for _, span := range []trace.Span{otherSpan, legacy, parent} {
+				span.End()
+			}
+
+			if recording.read("span") {
+				t.Error(`the hook read the "span" context key`)
+			}
+			if got := recording.read("spanCtx"); got != tt.wantLegacyRead {
+				t.Errorf(`"spanCtx" read %v, want %v`, got, tt.wantLegacyRead)
+			}
+			parents := make(map[trace.SpanID]string)
+			var query tracetest.SpanStub
+			for _, span := range exp.GetSpans() {
+				parents[span.SpanContext.SpanID()] = span.Name
+				if span.Name == "xorm-db" {
+					query = span
+				}
+			}
+			if query.SpanContext.SpanID() != started.SpanID() {
+				t.Errorf("AfterProcess ended span %s, want the span %s started in BeforeProcess", query.SpanContext.SpanID(), started.SpanID())
+			}
+			if got := parents[query.Parent.SpanID()]; got != tt.wantParent {
+				t.Errorf("query span parent = %q, want %q", got, tt.wantParent)
+			}
+			if query.EndTime.IsZero() {
+				t.Error("query span not ended")
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package otelxorm
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.18.0"
+	"testing"
+	"xorm.io/xorm"
+)
+
+func TestWrapEngineDBSystem(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  []Option
+		group bool
+		want  attribute.Value
+	}{
+		{"engine", nil, false, semconv.DBSystemSqlite.Value},
+		{"engine group", nil, true, semconv.DBSystemSqlite.Value},
+		{"WithDBSystem kept", []Option{WithDBSystem("libsql")}, false, attribute.StringValue("libsql")},
+		{"WithAttributes kept", []Option{WithAttributes(semconv.DBSystemMySQL)}, true, semconv.DBSystemMySQL.Value},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := tracetest.NewInMemoryExporter()
+			opts := append([]Option{WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)))}, tt.opts...)
+			engine, err := xorm.NewEngine("sqlite3", ":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer engine.Close()
+			if tt.group {
+				group, err := xorm.NewEngineGroup(engine, []*xorm.Engine{engine})
+				if err != nil {
+					t.Fatal(err)
+				}
+				WrapEngineGroup(group, opts...)
+			} else {
+				WrapEngine(engine, opts...)
+			}
+			if _, err := engine.QueryString("SELECT 1"); err != nil {
+				t.Fatal(err)
+			}
+
+			spans := exp.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			if v, ok := attrValue(spans[0].Attributes, semconv.DBSystemKey); !ok || v != tt.want {
+				t.Errorf("db.system = %q (present %v), want %q", v.Emit(), ok, tt.want.Emit())
+			}
+		})
+	}
+}
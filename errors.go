@@ -0,0 +1,42 @@
+package otelxorm
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"xorm.io/xorm"
+)
+
+// constraintErrorMarkers are substrings of the error messages of common
+// drivers when a constraint is violated. SQLSTATE class 23 is "integrity
+// constraint violation".
+var constraintErrorMarkers = []string{
+	"constraint",
+	"duplicate",
+	"unique",
+	"foreign key",
+	"sqlstate 23",
+}
+
+// isConstraintViolation reports whether err looks like a constraint
+// violation. The check is based on the error message, since drivers don't
+// share error types.
+func isConstraintViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, marker := range constraintErrorMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultErrorFilter is the error filter used without WithErrorFilter. It
+// reports false for the "no rows" errors of database/sql and xorm, which only
+// mean that a query found nothing.
+func DefaultErrorFilter(err error) bool {
+	return !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, xorm.ErrNotExist)
+}
@@ -0,0 +1,100 @@
+package otelxorm
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/codes"
+	"testing"
+	"xorm.io/xorm"
+)
+
+func TestIsConstraintViolation(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("Error 1062: Duplicate entry 'bob' for key 'name'"), true},
+		{errors.New(`pq: duplicate key value violates unique constraint "users_name_key"`), true},
+		{errors.New("UNIQUE constraint failed: users.name"), true},
+		{errors.New("Error 1452: Cannot add or update a child row: a foreign key constraint fails"), true},
+		{errors.New("ERROR: null value in column \"name\" (SQLSTATE 23502)"), true},
+		{errors.New("dial tcp 127.0.0.1:3306: connect: connection refused"), false},
+		{errors.New("Error 1146: Table 'app.users' doesn't exist"), false},
+	}
+	for _, tt := range tests {
+		if got := isConstraintViolation(tt.err); got != tt.want {
+			t.Errorf("isConstraintViolation(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRecordValuesOnConstraintError(t *testing.T) {
+	duplicate := errors.New("Error 1062: Duplicate entry 'bob' for key 'name'")
+	tests := []struct {
+		name      string
+		opts      []Option
+		err       error
+		wantEvent bool
+	}{
+		{"constraint error", []Option{WithRecordValuesOnConstraintError()}, duplicate, true},
+		{"other error", []Option{WithRecordValuesOnConstraintError()}, errors.New("connection refused"), false},
+		{"success", []Option{WithRecordValuesOnConstraintError()}, nil, false},
+		{"redacted", []Option{WithRecordValuesOnConstraintError(), WithRedactArgs()}, duplicate, false},
+		{"disabled", nil, duplicate, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{
+				sql:  "INSERT INTO users (name, age) VALUES (?, ?)",
+				args: []interface{}{"bob", 42},
+				err:  tt.err,
+			}, tt.opts...)
+			event, ok := eventNamed(span, "db.constraint_violation")
+			if ok != tt.wantEvent {
+				t.Fatalf("db.constraint_violation event present %v, want %v", ok, tt.wantEvent)
+			}
+			if !ok {
+				return
+			}
+			v, _ := attrValue(event.Attributes, "db.args")
+			if got := v.AsStringSlice(); len(got) != 2 || got[0] != "'bob'" || got[1] != "'42'" {
+				t.Errorf("db.args = %q, want ['bob' '42']", got)
+			}
+		})
+	}
+}
+
+func TestErrorFilter(t *testing.T) {
+	timeout := errors.New("i/o timeout")
+	tests := []struct {
+		name       string
+		opts       []Option
+		err        error
+		wantStatus codes.Code
+	}{
+		{"no rows", nil, sql.ErrNoRows, codes.Unset},
+		{"wrapped no rows", nil, fmt.Errorf("find user: %w", sql.ErrNoRows), codes.Unset},
+		{"xorm not exist", nil, xorm.ErrNotExist, codes.Unset},
+		{"other error", nil, timeout, codes.Error},
+		{"success", nil, nil, codes.Unset},
+		{"custom filter ignoring timeouts", []Option{WithErrorFilter(func(err error) bool { return !errors.Is(err, timeout) })}, timeout, codes.Unset},
+		{"custom filter replacing the default", []Option{WithErrorFilter(func(error) bool { return true })}, sql.ErrNoRows, codes.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: "SELECT * FROM users WHERE id = ?", args: []interface{}{1}, err: tt.err}, tt.opts...)
+			if span.Status.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", span.Status.Code, tt.wantStatus)
+			}
+			_, recorded := eventNamed(span, "exception")
+			if want := tt.wantStatus == codes.Error; recorded != want {
+				t.Errorf("exception event present %v, want %v", recorded, want)
+			}
+			if tt.wantStatus == codes.Unset && len(span.Events) != 0 {
+				t.Errorf("got events %v, want none", span.Events)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package otelxorm
+
+import (
+	"strings"
+	"sync"
+)
+
+// maxFingerprintSamples bounds the memory used by fingerprint collision
+// detection. Once full, new fingerprints are no longer stored but known ones
+// are still checked.
+const maxFingerprintSamples = 1024
+
+// fingerprintSamples maps fingerprints to the first statement seen with them.
+type fingerprintSamples struct {
+	mu      sync.Mutex
+	samples map[string]string
+}
+
+// collides records statement under fp and reports whether fp was already
+// taken by a different statement, returning that statement.
+func (s *fingerprintSamples) collides(fp, statement string) (string, bool) {
+	statement = strings.Join(strings.Fields(statement), " ")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.samples == nil {
+		s.samples = make(map[string]string)
+	}
+	sample, ok := s.samples[fp]
+	if !ok {
+		if len(s.samples) < maxFingerprintSamples {
+			s.samples[fp] = statement
+		}
+		return "", false
+	}
+	return sample, sample != statement
+}
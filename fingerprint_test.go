@@ -0,0 +1,66 @@
+package otelxorm
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFingerprintCollisionDetection(t *testing.T) {
+	h, exp := newTestHook(WithFingerprintCollisionDetection())
+	h.config.fingerprint = func(string) string { return "stub" }
+
+	testQuery{sql: "SELECT * FROM users"}.run(t, h)
+	testQuery{sql: "SELECT  *\n FROM users"}.run(t, h)
+	testQuery{sql: "SELECT * FROM orders"}.run(t, h)
+
+	spans := exp.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	for i, span := range spans[:2] {
+		if _, ok := attrValue(span.Attributes, "db.fingerprint.collision"); ok {
+			t.Errorf("span %d: collision reported for the same statement", i)
+		}
+	}
+	if v, ok := attrValue(spans[2].Attributes, "db.fingerprint.collision"); !ok || !v.AsBool() {
+		t.Error("no db.fingerprint.collision attribute for a colliding statement")
+	}
+	event, ok := eventNamed(spans[2], "db.fingerprint.collision")
+	if !ok {
+		t.Fatal("no db.fingerprint.collision event")
+	}
+	if v, _ := attrValue(event.Attributes, "db.fingerprint"); v.AsString() != "stub" {
+		t.Errorf("db.fingerprint = %q, want stub", v.AsString())
+	}
+	if v, _ := attrValue(event.Attributes, "db.fingerprint.sample"); v.AsString() != "SELECT * FROM users" {
+		t.Errorf("db.fingerprint.sample = %q, want the first statement", v.AsString())
+	}
+}
+
+func TestFingerprintCollisionDetectionDisabled(t *testing.T) {
+	h, exp := newTestHook()
+	h.config.fingerprint = func(string) string { return "stub" }
+	testQuery{sql: "SELECT * FROM users"}.run(t, h)
+	testQuery{sql: "SELECT * FROM orders"}.run(t, h)
+	for _, span := range exp.GetSpans() {
+		if _, ok := attrValue(span.Attributes, "db.fingerprint.collision"); ok {
+			t.Error("collision reported without WithFingerprintCollisionDetection")
+		}
+	}
+}
+
+func TestFingerprintSamplesBounded(t *testing.T) {
+	var s fingerprintSamples
+	for i := 0; i < maxFingerprintSamples+10; i++ {
+		s.collides(strconv.Itoa(i), "SELECT "+strconv.Itoa(i))
+	}
+	if len(s.samples) != maxFingerprintSamples {
+		t.Errorf("stored %d samples, want %d", len(s.samples), maxFingerprintSamples)
+	}
+	if sample, ok := s.collides("0", "SELECT other"); !ok || sample != "SELECT 0" {
+		t.Errorf("collides on a stored fingerprint = %q, %v, want SELECT 0, true", sample, ok)
+	}
+	if _, ok := s.collides(strconv.Itoa(maxFingerprintSamples+5), "SELECT other"); ok {
+		t.Error("collision reported for a fingerprint that was not stored")
+	}
+}
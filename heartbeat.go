@@ -0,0 +1,56 @@
+package otelxorm
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// maxHeartbeats bounds the still_running events of one span, below the
+// default span event limit of the SDK.
+const maxHeartbeats = 100
+
+// heartbeat adds still_running events to a span until stopped.
+type heartbeat struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startHeartbeat adds a still_running event to span every interval until
+// stopHeartbeat is called, ctx is done or maxHeartbeats events were added.
+// The bound matters because AfterProcess, which stops the heartbeat, doesn't
+// always run or see the heartbeat: xorm skips AfterProcess when a later
+// hook's BeforeProcess fails, and drops the context returned by every hook
+// but the last one.
+func startHeartbeat(ctx context.Context, span trace.Span, interval time.Duration) *heartbeat {
+	hb := &heartbeat{stop: make(chan struct{}), done: make(chan struct{})}
+	start := time.Now()
+	go func() {
+		defer close(hb.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for beats := 0; beats < maxHeartbeats; beats++ {
+			select {
+			case <-ticker.C:
+				span.AddEvent("still_running", trace.WithAttributes(
+					attribute.Key("db.elapsed_ms").Float64(durationMs(time.Since(start))),
+				))
+			case <-hb.stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return hb
+}
+
+// stopHeartbeat stops the heartbeat started for ctx, if any, and waits for
+// its goroutine to exit.
+func stopHeartbeat(ctx context.Context) {
+	if hb, ok := ctx.Value(heartbeatKey{}).(*heartbeat); ok {
+		close(hb.stop)
+		<-hb.done
+	}
+}
@@ -0,0 +1,71 @@
+package otelxorm
+
+import (
+	"context"
+	"testing"
+	"time"
+	"xorm.io/xorm/contexts"
+)
+
+func TestHeartbeat(t *testing.T) {
+	h, exp := newTestHook(WithHeartbeat(time.Millisecond))
+	c := contexts.NewContextHook(context.Background(), "SELECT SLEEP(1)", nil)
+	ctx, err := h.BeforeProcess(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	c.End(ctx, nil, nil)
+	if err := h.AfterProcess(c); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exp.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	event, ok := eventNamed(spans[0], "still_running")
+	if !ok {
+		t.Fatal("no still_running event")
+	}
+	if v, _ := attrValue(event.Attributes, "db.elapsed_ms"); v.AsFloat64() <= 0 {
+		t.Errorf("db.elapsed_ms = %v, want > 0", v.AsFloat64())
+	}
+}
+
+func TestHeartbeatBounded(t *testing.T) {
+	h, exp := newTestHook()
+	_, span := h.config.tracer.Start(context.Background(), "query")
+	// Never stopped, as when AfterProcess doesn't run.
+	hb := startHeartbeat(context.Background(), span, time.Microsecond)
+	select {
+	case <-hb.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("heartbeat still running")
+	}
+	span.End()
+
+	beats := 0
+	for _, event := range exp.GetSpans()[0].Events {
+		if event.Name == "still_running" {
+			beats++
+		}
+	}
+	if beats != maxHeartbeats {
+		t.Errorf("got %d still_running events, want %d", beats, maxHeartbeats)
+	}
+}
+
+func TestHeartbeatStopsWithContext(t *testing.T) {
+	h, _ := newTestHook()
+	_, span := h.config.tracer.Start(context.Background(), "query")
+	defer span.End()
+	ctx, cancel := context.WithCancel(context.Background())
+	hb := startHeartbeat(ctx, span, time.Hour)
+	cancel()
+	select {
+	case <-hb.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("heartbeat still running after the context was canceled")
+	}
+}
@@ -0,0 +1,62 @@
+package otelxorm
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/instrument"
+	semconv "go.opentelemetry.io/otel/semconv/v1.18.0"
+	"xorm.io/xorm/contexts"
+)
+
+// queryMetrics records the metrics enabled by WithMeterProvider.
+type queryMetrics struct {
+	duration instrument.Float64Histogram
+	queries  instrument.Int64Counter
+	// attrs are the db.system and db.name attributes shared by every
+	// measurement.
+	attrs []attribute.KeyValue
+}
+
+// newQueryMetrics creates the query instruments of mp. It returns nil, after
+// reporting the error to the global error handler, if an instrument cannot be
+// created.
+func newQueryMetrics(mp metric.MeterProvider, attrs []attribute.KeyValue) *queryMetrics {
+	meter := mp.Meter(tracerName, metric.WithInstrumentationVersion(SemVersion()))
+	duration, err := meter.Float64Histogram("db.client.operation.duration",
+		instrument.WithUnit("s"),
+		instrument.WithDescription("Duration of database client operations."),
+	)
+	if err != nil {
+		otel.Handle(err)
+		return nil
+	}
+	queries, err := meter.Int64Counter("db.client.queries",
+		instrument.WithUnit("{query}"),
+		instrument.WithDescription("Number of database client operations."),
+	)
+	if err != nil {
+		otel.Handle(err)
+		return nil
+	}
+	m := &queryMetrics{duration: duration, queries: queries}
+	for _, attr := range attrs {
+		if attr.Key == semconv.DBSystemKey || attr.Key == semconv.DBNameKey {
+			m.attrs = append(m.attrs, attr)
+		}
+	}
+	return m
+}
+
+// record adds the measurements of the query completed in c. failed reports
+// whether the query error passed the error filter.
+func (m *queryMetrics) record(c *contexts.ContextHook, stmtType StatementType, failed bool) {
+	attrs := make([]attribute.KeyValue, 0, len(m.attrs)+2)
+	attrs = append(attrs, m.attrs...)
+	if stmtType != StatementUnknown {
+		attrs = append(attrs, semconv.DBOperation(string(stmtType)))
+	}
+	attrs = append(attrs, attribute.Key("error").Bool(failed))
+	m.duration.Record(c.Ctx, c.ExecuteTime.Seconds(), attrs...)
+	m.queries.Add(c.Ctx, 1, attrs...)
+}
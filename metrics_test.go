@@ -0,0 +1,171 @@
+package otelxorm
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+	"time"
+	"xorm.io/xorm/contexts"
+)
+
+// collectMetrics returns the metrics recorded by the hook, by name.
+func collectMetrics(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+	return metrics
+}
+
+func TestMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	h, _ := newTestHook(
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		WithDBSystem("mysql"),
+		WithDBName("app"),
+	)
+	testQuery{sql: "SELECT 1", duration: 2 * time.Second}.run(t, h)
+	testQuery{sql: "SELECT 2", duration: time.Second}.run(t, h)
+	testQuery{sql: "UPDATE users SET name = ?", err: errors.New("deadlock"), duration: 500 * time.Millisecond}.run(t, h)
+
+	metrics := collectMetrics(t, reader)
+	histogram, ok := metrics["db.client.operation.duration"].(metricdata.Histogram)
+	if !ok {
+		t.Fatalf("db.client.operation.duration is %T, want a histogram", metrics["db.client.operation.duration"])
+	}
+	counter, ok := metrics["db.client.queries"].(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("db.client.queries is %T, want an int64 sum", metrics["db.client.queries"])
+	}
+
+	type series struct {
+		operation string
+		failed    bool
+	}
+	want := map[series]struct {
+		count uint64
+		sum   float64
+	}{
+		{"SELECT", false}: {2, 3},
+		{"UPDATE", true}:  {1, 0.5},
+	}
+	seriesOf := func(set attribute.Set) series {
+		if v, ok := set.Value("db.system"); !ok || v.AsString() != "mysql" {
+			t.Errorf("db.system = %q, want mysql", v.AsString())
+		}
+		if v, ok := set.Value("db.name"); !ok || v.AsString() != "app" {
+			t.Errorf("db.name = %q, want app", v.AsString())
+		}
+		op, _ := set.Value("db.operation")
+		failed, _ := set.Value("error")
+		return series{op.AsString(), failed.AsBool()}
+	}
+	if len(histogram.DataPoints) != len(want) {
+		t.Fatalf("got %d histogram series, want %d", len(histogram.DataPoints), len(want))
+	}
+	var total uint64
+	for _, dp := range histogram.DataPoints {
+		s := seriesOf(dp.Attributes)
+		if w := want[s]; dp.Count != w.count || dp.Sum != w.sum {
+			t.Errorf("%+v: count = %d, sum = %v, want %d and %v", s, dp.Count, dp.Sum, w.count, w.sum)
+		}
+		total += dp.Count
+	}
+	if total != 3 {
+		t.Errorf("got %d histogram data points, want one per query", total)
+	}
+	for _, dp := range counter.DataPoints {
+		s := seriesOf(dp.Attributes)
+		if w := want[s]; uint64(dp.Value) != w.count {
+			t.Errorf("%+v: db.client.queries = %d, want %d", s, dp.Value, w.count)
+		}
+	}
+}
+
+func TestMetricsRecordedForUnsampledSpans(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	h, _ := newTestHook(
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+		WithInheritParentSampling(),
+	)
+	testQuery{sql: "SELECT 1"}.run(t, h)
+
+	histogram, _ := collectMetrics(t, reader)["db.client.operation.duration"].(metricdata.Histogram)
+	if len(histogram.DataPoints) != 1 || histogram.DataPoints[0].Count != 1 {
+		t.Errorf("histogram data points = %+v, want one query", histogram.DataPoints)
+	}
+}
+
+func TestFilterUnderUnsampledParent(t *testing.T) {
+	unsampled := trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{1},
+		Remote:  true,
+	}))
+	tests := []struct {
+		name string
+		sql  string
+		// lateSQL leaves the SQL out of BeforeProcess, so that the filter
+		// runs in AfterProcess.
+		lateSQL     bool
+		wantQueries uint64
+	}{
+		{"rejected", "SELECT 1", false, 0},
+		{"rejected in AfterProcess", "SELECT 1", true, 0},
+		{"accepted", "SELECT * FROM users", false, 1},
+		{"accepted in AfterProcess", "SELECT * FROM users", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := sdkmetric.NewManualReader()
+			h, exp := newTestHook(
+				WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+				WithInheritParentSampling(),
+				WithFilter(func(sql string, args []interface{}) bool { return sql != "SELECT 1" }),
+			)
+			c := contexts.NewContextHook(unsampled, tt.sql, nil)
+			if tt.lateSQL {
+				c.SQL = ""
+			}
+			ctx, err := h.BeforeProcess(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.SQL = tt.sql
+			c.End(ctx, nil, nil)
+			if err := h.AfterProcess(c); err != nil {
+				t.Fatal(err)
+			}
+
+			if spans := exp.GetSpans(); len(spans) != 0 {
+				t.Errorf("got %d spans under an unsampled parent, want 0", len(spans))
+			}
+			histogram, _ := collectMetrics(t, reader)["db.client.operation.duration"].(metricdata.Histogram)
+			var queries uint64
+			for _, dp := range histogram.DataPoints {
+				queries += dp.Count
+			}
+			if queries != tt.wantQueries {
+				t.Errorf("measured %d queries, want %d", queries, tt.wantQueries)
+			}
+		})
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	h, _ := newTestHook()
+	if h.config.metrics != nil {
+		t.Error("metrics enabled without WithMeterProvider")
+	}
+}
@@ -0,0 +1,63 @@
+package otelxorm
+
+import "strings"
+
+// Normalizer rewrites a statement into a dialect-neutral form.
+type Normalizer interface {
+	Normalize(sql string) string
+}
+
+// NormalizerFunc adapts a function to the Normalizer interface.
+type NormalizerFunc func(sql string) string
+
+// Normalize calls f(sql).
+func (f NormalizerFunc) Normalize(sql string) string {
+	return f(sql)
+}
+
+// DefaultNormalizer unquotes identifiers quoted with backticks, double quotes
+// or brackets, rewrites $1, :name and @name placeholders to ? and collapses
+// whitespace, so that e.g. the MySQL and PostgreSQL forms of a query
+// normalize to the same string. String literals and PostgreSQL ::type casts
+// are kept as they are.
+var DefaultNormalizer Normalizer = NormalizerFunc(normalizeStatement)
+
+func normalizeStatement(sql string) string {
+	var sb strings.Builder
+	var prev sqlToken
+	for i, t := range tokenize(sql) {
+		text := t.text
+		if t.kind == tokenPlaceholder {
+			text = "?"
+		}
+		if i > 0 && needsSpace(prev, t) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(text)
+		prev = t
+	}
+	return sb.String()
+}
+
+// needsSpace reports whether a space separates prev and next in a normalized
+// statement.
+func needsSpace(prev, next sqlToken) bool {
+	if prev.kind == tokenPunct && (prev.text == "(" || prev.text == "." || prev.text == "::") {
+		return false
+	}
+	if next.kind == tokenPunct && (next.text == "," || next.text == ")" || next.text == "." || next.text == "::") {
+		return false
+	}
+	if next.kind == tokenPunct && next.text == "(" && prev.kind != tokenPunct {
+		// Function calls and table column lists hug the parenthesis,
+		// keywords such as IN or VALUES don't.
+		return prev.kind == tokenWord && parenKeywords[strings.ToUpper(prev.text)]
+	}
+	return true
+}
+
+var parenKeywords = map[string]bool{
+	"IN": true, "VALUES": true, "VALUE": true, "AS": true, "ON": true, "AND": true, "OR": true,
+	"NOT": true, "WHERE": true, "FROM": true, "JOIN": true, "EXISTS": true, "USING": true,
+	"SELECT": true, "SET": true, "ANY": true, "ALL": true, "WITH": true,
+}
@@ -0,0 +1,54 @@
+package otelxorm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultNormalizer(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{"mysql", "SELECT `id`, `name` FROM `users` WHERE `id` = ?", "SELECT id, name FROM users WHERE id = ?"},
+		{"postgres", `SELECT "id", "name" FROM "users" WHERE "id" = $1`, "SELECT id, name FROM users WHERE id = ?"},
+		{"sql server", "SELECT [id], [name] FROM [users] WHERE [id] = @p1", "SELECT id, name FROM users WHERE id = ?"},
+		{"named", "SELECT * FROM users WHERE id = :id", "SELECT * FROM users WHERE id = ?"},
+		{"whitespace", "SELECT *\n\tFROM  users\n WHERE id IN (?, ?)", "SELECT * FROM users WHERE id IN (?, ?)"},
+		{"function call", "SELECT COUNT(*) FROM users", "SELECT COUNT(*) FROM users"},
+		{"string literal kept", "SELECT * FROM users WHERE name = 'a  `b` $1'", "SELECT * FROM users WHERE name = 'a  `b` $1'"},
+		{"cast", "SELECT created_at::date FROM users", "SELECT created_at::date FROM users"},
+		{"cast after placeholder", "SELECT * FROM users WHERE created_at > $1::timestamptz", "SELECT * FROM users WHERE created_at > ?::timestamptz"},
+		{"cast and named placeholder", "SELECT * FROM users WHERE id = :id::int", "SELECT * FROM users WHERE id = ?::int"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DefaultNormalizer.Normalize(tt.sql); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultNormalizerDialectsAgree(t *testing.T) {
+	mysql := DefaultNormalizer.Normalize("SELECT `u`.`id` FROM `users` `u` JOIN `orders` `o` ON `o`.`user_id` = `u`.`id` WHERE `o`.`total` > ?")
+	postgres := DefaultNormalizer.Normalize(`SELECT "u"."id" FROM "users" "u" JOIN "orders" "o" ON "o"."user_id" = "u"."id" WHERE "o"."total" > $1`)
+	if mysql != postgres {
+		t.Errorf("MySQL form %q and PostgreSQL form %q differ", mysql, postgres)
+	}
+}
+
+func TestDialectNormalizer(t *testing.T) {
+	sql := `SELECT "id" FROM "users" WHERE "id" = $1`
+	span := runQuery(t, testQuery{sql: sql}, WithDialectNormalizer(DefaultNormalizer))
+	if v, _ := attrValue(span.Attributes, "db.statement.normalized"); v.AsString() != "SELECT id FROM users WHERE id = ?" {
+		t.Errorf("db.statement.normalized = %q", v.AsString())
+	}
+
+	upper := NormalizerFunc(strings.ToUpper)
+	span = runQuery(t, testQuery{sql: "select 1"}, WithDialectNormalizer(upper))
+	if v, _ := attrValue(span.Attributes, "db.statement.normalized"); v.AsString() != "SELECT 1" {
+		t.Errorf("db.statement.normalized = %q, want the custom normalizer's output", v.AsString())
+	}
+}
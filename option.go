@@ -1,14 +1,21 @@
 package otelxorm
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	semconv "go.opentelemetry.io/otel/semconv/v1.18.0"
 	"go.opentelemetry.io/otel/trace"
-	"regexp"
+	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 	"xorm.io/xorm/contexts"
 )
 
@@ -22,16 +29,202 @@ func (o optionFunc) apply(c *config) {
 	o(c)
 }
 
+// Options is a list of options applied in order. It is itself an Option, so
+// a platform team can export one value holding its defaults.
+type Options []Option
+
+func (o Options) apply(c *config) {
+	for _, opt := range o {
+		opt.apply(c)
+	}
+}
+
+// Apply returns the options of o followed by opts, without modifying o. It
+// extends shared defaults, e.g. CompanyDefaults.Apply(WithDBName("orders")):
+// as options apply in order, opts override the defaults they conflict with.
+func (o Options) Apply(opts ...Option) Options {
+	applied := make(Options, 0, len(o)+len(opts))
+	applied = append(applied, o...)
+	return append(applied, opts...)
+}
+
+// Bundle collapses opts into a single Option. Applying the bundle is the same
+// as applying opts individually, in order.
+func Bundle(opts ...Option) Option {
+	return Options(opts)
+}
+
 type config struct {
 	dbName         string
+	spanName       string
 	tracerProvider trace.TracerProvider
 	tracer         trace.Tracer
 	attrs          []attribute.KeyValue
+	staticAttrs    []attribute.KeyValue
 	beforeHook     func(c *contexts.ContextHook)
 	afterHook      func(c *contexts.ContextHook)
 	formatSQL      func(sql string, args []interface{}) string
+	formatSQLName  string
+
+	// clientStartOpts are the span start options of client spans, built
+	// once in Hook.
+	clientStartOpts []trace.SpanStartOption
+
+	tagMigrations     bool
+	migrationSpanName string
+
+	dbVersionFunc    func() (string, bool)
+	dbVersionLookups int32        // 1 while dbVersionFunc runs
+	dbVersion        atomic.Value // string
+
+	spanKinds map[StatementType]trace.SpanKind
+
+	massMutationThreshold int64
+
+	recordPreparedReuse bool
+
+	columnCountFunc func(c *contexts.ContextHook) (int, bool)
+
+	recordParentOperation bool
+
+	valueFormat valueFormat
+
+	maxAttributeLength int
+	queryPlanFunc      func(c *contexts.ContextHook) (string, bool)
+
+	hostname     string
+	autoHostname bool
+
+	recordComplexity bool
+
+	recordErrorType bool
+	unwrapErrorType bool
+
+	conditionalAttrs []conditionalAttributes
+
+	recordValuesOnConstraintError bool
+
+	traceStateMutator func(ts trace.TraceState) trace.TraceState
+
+	lazySpanNaming bool
+
+	recordArgs     bool
+	recordArgsJSON bool
+	maxArgs        int
+
+	serverTimeFunc func(c *contexts.ContextHook) (time.Duration, bool)
+
+	errorAttrs []attribute.KeyValue
+
+	batchPositionFunc func(ctx context.Context) (index, total int, ok bool)
+
+	replicaInfoFunc func(c *contexts.ContextHook) (name string, lagMs int64, ok bool)
+
+	slowThreshold            time.Duration
+	slowThresholdByOperation map[StatementType]time.Duration
+
+	queueTimeFunc func(ctx context.Context) (time.Duration, bool)
+
+	normalizer Normalizer
+
+	recordClientTimezone bool
+
+	inheritParentSampling bool
+
+	xormMethodFunc func(c *contexts.ContextHook) (string, bool)
+
+	connectionReusedFunc func(c *contexts.ContextHook) (reused bool, ok bool)
+
+	statementAttrs []statementAttribute
+
+	txIDFunc func(ctx context.Context) (string, bool)
+
+	upstreamCacheStatusFunc func(ctx context.Context) (string, bool)
+
+	recordLimit             bool
+	recordOffset            bool
+	deepPaginationThreshold int
+
+	recordSortColumns bool
+
+	flagIndexUnfriendly bool
+
+	recordJoinCount bool
+
+	idempotencyClassifier func(c *contexts.ContextHook, parsed ParsedSQL) bool
+
+	flagUnparameterized bool
+
+	parser StatementParser
+
+	fingerprint        func(sql string) string
+	fingerprintSamples *fingerprintSamples
+
+	recordPaginationStyle bool
+
+	resultSizeFunc func(c *contexts.ContextHook) (bytes int64, ok bool)
+
+	formatSQLByOperation map[StatementType]func(sql string, args []interface{}) string
+
+	recordSiblingIndex bool
+
+	spanNameFormatter func(sql string, args []interface{}) string
+
+	heartbeatInterval time.Duration
+
+	schemaVersionFunc    func() (string, bool)
+	schemaVersionTTL     time.Duration
+	schemaVersionLookups int32
+	schemaVersion        atomic.Value // schemaVersionEntry
+
+	meterProvider metric.MeterProvider
+	metrics       *queryMetrics
+
+	tableAttrs map[string][]attribute.KeyValue
+
+	recordExecPath bool
+
+	contextKey interface{}
+
+	recordArgValues map[string]bool
+	redactArgs      bool
+
+	recordAppCaller bool
+
+	argsRedactor func(args []interface{}) []interface{}
+
+	debugLogger func(format string, args ...interface{})
+
+	disableStatement bool
+
+	unifiedRowCount bool
+	readCountFunc   func(c *contexts.ContextHook) (int64, bool)
+
+	structuredAttrs bool
+
+	errorFilter func(err error) bool
+
+	filter func(sql string, args []interface{}) bool
+}
+
+type statementAttribute struct {
+	key       attribute.Key
+	extractor func(parsed ParsedSQL) string
 }
 
+type conditionalAttributes struct {
+	pred  func(c *contexts.ContextHook) bool
+	attrs []attribute.KeyValue
+}
+
+// valueFormat controls how WithFormatSQLReplace renders argument values.
+type valueFormat struct {
+	timeLayout   string
+	timeLocation *time.Location
+}
+
+const defaultTimeLayout = "2006-01-02 15:04:05"
+
 // WithTracerProvider with tracer provider.
 func WithTracerProvider(provider trace.TracerProvider) Option {
 	return optionFunc(func(cfg *config) {
@@ -39,8 +232,54 @@ func WithTracerProvider(provider trace.TracerProvider) Option {
 	})
 }
 
+// WithMeterProvider records a db.client.operation.duration histogram and a
+// db.client.queries counter for every query, with the db.system, db.name,
+// db.operation and error attributes. No metrics are recorded without it.
+// Metrics are independent of sampling: unsampled queries are measured too.
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return optionFunc(func(c *config) {
+		c.meterProvider = provider
+	})
+}
+
+// WithContextKey starts spans under the context.Context stored in the query
+// context under key, for applications that keep their traced context there
+// instead of passing it to the xorm session. Queries without such a value are
+// traced under the query context itself. Without this option, a query
+// context carrying no span falls back to the context.Context stored under
+// the string key "spanCtx", which is deprecated.
+func WithContextKey(key interface{}) Option {
+	return optionFunc(func(c *config) {
+		c.contextKey = key
+	})
+}
+
+// WithSpanName sets the name of database spans. By default spans are named
+// after the db.name attribute, or "xorm-db" without one.
+func WithSpanName(name string) Option {
+	return optionFunc(func(c *config) {
+		c.spanName = name
+	})
+}
+
+// WithSpanNameFormatter names spans with fn, e.g. SpanNameFromSQL. fn is
+// called in BeforeProcess; if the SQL isn't known yet the span is renamed in
+// AfterProcess instead. When fn returns "" the default name is kept.
+func WithSpanNameFormatter(fn func(sql string, args []interface{}) string) Option {
+	return optionFunc(func(c *config) {
+		c.spanNameFormatter = fn
+	})
+}
+
+// WithAttributes configures attributes that are set on every span.
+func WithAttributes(attrs ...attribute.KeyValue) Option {
+	return optionFunc(func(c *config) {
+		c.attrs = append(c.attrs, attrs...)
+	})
+}
+
 // WithDBSystem configures a db.system attribute. You should prefer using
-// WithAttributes and semconv, for example, `otelsql.WithAttributes(semconv.DBSystemSqlite)`.
+// WithAttributes and semconv, for example, `otelxorm.WithAttributes(semconv.DBSystemSqlite)`.
 func WithDBSystem(system string) Option {
 	return optionFunc(func(c *config) {
 		c.attrs = append(c.attrs, semconv.DBSystemKey.String(system))
@@ -57,11 +296,700 @@ func WithDBName(name string) Option {
 func WithFormatSQL(formatSQL func(sql string, args []interface{}) string) Option {
 	return optionFunc(func(c *config) {
 		c.formatSQL = formatSQL
+		c.formatSQLName = "custom"
+	})
+}
+
+// WithDisableDBStatement keeps the SQL out of spans: db.statement,
+// db.statement.normalized and the db.fingerprint.sample of fingerprint
+// collision events are not recorded and the statement formatter is never
+// called. The rest of the span, such as the error status, is kept.
+func WithDisableDBStatement() Option {
+	return optionFunc(func(c *config) {
+		c.disableStatement = true
+	})
+}
+
+// WithFormatSQLByOperation picks the statement formatter by statement type,
+// e.g. to redact writes but show reads. Statement types missing from
+// formatters use the formatter set by WithFormatSQL or WithFormatSQLReplace.
+func WithFormatSQLByOperation(formatters map[StatementType]func(sql string, args []interface{}) string) Option {
+	return optionFunc(func(c *config) {
+		c.formatSQLByOperation = formatters
 	})
 }
 
 func WithFormatSQLReplace() Option {
-	return WithFormatSQL(formatSQLReplace)
+	return optionFunc(func(c *config) {
+		c.formatSQL = func(sql string, args []interface{}) string {
+			return c.valueFormat.formatSQLReplace(sql, args)
+		}
+		c.formatSQLName = "replace"
+	})
+}
+
+// WithTimeLayout sets the layout used by WithFormatSQLReplace for time.Time
+// values. The default, "2006-01-02 15:04:05", drops the zone; use a layout
+// such as time.RFC3339 to keep the offset.
+func WithTimeLayout(layout string) Option {
+	return optionFunc(func(c *config) {
+		c.valueFormat.timeLayout = layout
+	})
+}
+
+// WithTimeLayoutUTC makes WithFormatSQLReplace convert time.Time values to
+// UTC before formatting them. By default times are rendered in their own
+// location. It and WithClientTimezone both set the rendering location: the
+// last one applied wins.
+func WithTimeLayoutUTC() Option {
+	return optionFunc(func(c *config) {
+		c.valueFormat.timeLocation = time.UTC
+	})
+}
+
+// WithClientTimezone makes WithFormatSQLReplace render time.Time values in
+// loc, time.Local if nil, and records the zone they are rendered in as
+// db.client.timezone. It and WithTimeLayoutUTC both set the rendering
+// location: the last one applied wins, and db.client.timezone names the zone
+// actually used. The zone is recorded by its IANA name, such as
+// "Europe/Paris"; for time.Local the name is taken from $TZ or the
+// /etc/localtime link, and is "Local" if neither names a zone.
+func WithClientTimezone(loc *time.Location) Option {
+	return optionFunc(func(c *config) {
+		if loc == nil {
+			loc = time.Local
+		}
+		c.valueFormat.timeLocation = loc
+		c.recordClientTimezone = true
+	})
+}
+
+// WithTagMigrations sets db.migration=true on spans of DDL statements
+// (CREATE/ALTER/DROP/TRUNCATE/RENAME).
+func WithTagMigrations() Option {
+	return optionFunc(func(c *config) {
+		c.tagMigrations = true
+	})
+}
+
+// WithMigrationSpanName uses name as the span name of DDL statements. It
+// implies WithTagMigrations.
+func WithMigrationSpanName(name string) Option {
+	return optionFunc(func(c *config) {
+		c.tagMigrations = true
+		c.migrationSpanName = name
+	})
+}
+
+// WithDBVersion configures a static db.version attribute.
+func WithDBVersion(version string) Option {
+	return WithDBVersionFunc(func() (string, bool) {
+		return version, version != ""
+	})
+}
+
+// WithDBVersionFunc configures a db.version attribute looked up by fn. fn is
+// called on the first query and its result is reused for every span. If fn
+// reports false the attribute is omitted and fn is called again on the next
+// query. fn may run a query through the hooked engine: spans completed while
+// fn runs, including that query's, have no db.version.
+func WithDBVersionFunc(fn func() (string, bool)) Option {
+	return optionFunc(func(c *config) {
+		c.dbVersionFunc = fn
+	})
+}
+
+// WithSchemaVersionFunc configures a db.schema.version attribute looked up by
+// fn, such as the latest applied migration, to correlate query behaviour with
+// schema changes. fn is called on the first query and its result is reused
+// for every span, unless WithSchemaVersionTTL allows it to be refreshed. If
+// fn reports false the attribute is omitted and the next query calls fn
+// again. fn may query the database through the hooked engine; that query is
+// recorded without the attribute.
+func WithSchemaVersionFunc(fn func() (string, bool)) Option {
+	return optionFunc(func(c *config) {
+		c.schemaVersionFunc = fn
+	})
+}
+
+// WithSchemaVersionTTL makes the schema version of WithSchemaVersionFunc
+// expire after ttl: the first query after that calls the func again. With a
+// zero ttl, the default, the version is looked up only once.
+func WithSchemaVersionTTL(ttl time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.schemaVersionTTL = ttl
+	})
+}
+
+// WithSpanKindByOperation overrides the span kind (SpanKindClient by default)
+// per statement type. The kind has to be chosen when the span starts, so it is
+// picked from a pre-parse of the SQL available in BeforeProcess.
+func WithSpanKindByOperation(kinds map[StatementType]trace.SpanKind) Option {
+	return optionFunc(func(c *config) {
+		c.spanKinds = kinds
+	})
+}
+
+// WithUnifiedRowCount sets db.rows to the number of rows a statement
+// touched: the rows affected for INSERT, UPDATE, DELETE and REPLACE, and for
+// SELECT the rows returned as reported by readCountFunc, since xorm doesn't
+// give them to hooks. readCountFunc may be nil to count writes only, or to
+// keep the func set by WithRowsReturnedFunc.
+func WithUnifiedRowCount(readCountFunc func(c *contexts.ContextHook) (int64, bool)) Option {
+	return optionFunc(func(c *config) {
+		c.unifiedRowCount = true
+		if readCountFunc != nil {
+			c.readCountFunc = readCountFunc
+		}
+	})
+}
+
+// WithRowsReturnedFunc sets db.rows.returned on SELECT spans to the number of
+// rows fn reports, e.g. counted by the application. xorm doesn't give the
+// result set to hooks, so without fn the attribute is omitted. Writes get
+// db.rows.affected from their result instead.
+func WithRowsReturnedFunc(fn func(c *contexts.ContextHook) (int64, bool)) Option {
+	return optionFunc(func(c *config) {
+		c.readCountFunc = fn
+	})
+}
+
+// WithMassMutationThreshold flags writes whose RowsAffected exceeds n with a
+// db.mass_mutation=true attribute and a mass_mutation span event.
+func WithMassMutationThreshold(n int64) Option {
+	return optionFunc(func(c *config) {
+		c.massMutationThreshold = n
+	})
+}
+
+// WithRecordPreparedReuse sets db.prepared.reuse to the number of earlier
+// executions of the same statement in the scope created by
+// WithPreparedReuseScope. Contexts without a scope are not counted.
+func WithRecordPreparedReuse() Option {
+	return optionFunc(func(c *config) {
+		c.recordPreparedReuse = true
+	})
+}
+
+// WithColumnCountFunc sets db.columns.count from fn. The hook cannot see the
+// columns returned by the driver, so the count has to come from the caller;
+// the attribute is omitted when fn reports false.
+func WithColumnCountFunc(fn func(c *contexts.ContextHook) (int, bool)) Option {
+	return optionFunc(func(c *config) {
+		c.columnCountFunc = fn
+	})
+}
+
+// WithRecordAppCaller sets code.namespace, code.function, code.filepath and
+// code.lineno to the application call site of the query: the first frame of
+// the stack outside xorm, database/sql and this package. Only the innermost
+// frames are inspected, so a call site buried deep under other libraries is
+// not recorded.
+func WithRecordAppCaller() Option {
+	return optionFunc(func(c *config) {
+		c.recordAppCaller = true
+	})
+}
+
+// WithRecordParentOperation copies the name of the parent span into
+// db.caller.operation. Only parents exposing their name (such as spans of the
+// OpenTelemetry SDK) are recorded.
+func WithRecordParentOperation() Option {
+	return optionFunc(func(c *config) {
+		c.recordParentOperation = true
+	})
+}
+
+// WithMaxAttributeLength truncates db.statement and other potentially large
+// string values recorded by the hook to n bytes. Zero means no limit.
+func WithMaxAttributeLength(n int) Option {
+	return optionFunc(func(c *config) {
+		c.maxAttributeLength = n
+	})
+}
+
+// WithQueryPlanFunc records the plan returned by fn as a db.query.plan span
+// event. Plans can be large, so they are not recorded as span attributes.
+func WithQueryPlanFunc(fn func(c *contexts.ContextHook) (string, bool)) Option {
+	return optionFunc(func(c *config) {
+		c.queryPlanFunc = fn
+	})
+}
+
+// WithHostname configures a host.name attribute.
+func WithHostname(host string) Option {
+	return optionFunc(func(c *config) {
+		c.hostname = host
+	})
+}
+
+// WithAutoHostname configures a host.name attribute read from os.Hostname
+// once, when the hook is created. WithHostname takes precedence.
+func WithAutoHostname() Option {
+	return optionFunc(func(c *config) {
+		c.autoHostname = true
+	})
+}
+
+// WithRecordComplexity sets db.query.complexity to a heuristic score of the
+// statement: JOINs and subqueries count twice, WHERE/ON conditions once.
+func WithRecordComplexity() Option {
+	return optionFunc(func(c *config) {
+		c.recordComplexity = true
+	})
+}
+
+// WithRecordErrorType sets db.error.type to the Go type of the query error,
+// e.g. "*mysql.MySQLError".
+func WithRecordErrorType() Option {
+	return optionFunc(func(c *config) {
+		c.recordErrorType = true
+	})
+}
+
+// WithRecordInnermostErrorType is like WithRecordErrorType but records the
+// type of the innermost error found by errors.Unwrap.
+func WithRecordInnermostErrorType() Option {
+	return optionFunc(func(c *config) {
+		c.recordErrorType = true
+		c.unwrapErrorType = true
+	})
+}
+
+// WithConditionalAttributes attaches attrs to the spans of queries for which
+// pred returns true. It can be used several times.
+func WithConditionalAttributes(pred func(c *contexts.ContextHook) bool, attrs ...attribute.KeyValue) Option {
+	return optionFunc(func(c *config) {
+		c.conditionalAttrs = append(c.conditionalAttrs, conditionalAttributes{pred: pred, attrs: attrs})
+	})
+}
+
+// WithRecordValuesOnConstraintError records the bound values as a
+// db.constraint_violation span event when the query fails with what looks
+// like a constraint violation. Values are sensitive, so this is off by
+// default.
+func WithRecordValuesOnConstraintError() Option {
+	return optionFunc(func(c *config) {
+		c.recordValuesOnConstraintError = true
+	})
+}
+
+// WithTraceStateMutator sets the W3C tracestate of database spans to the
+// result of fn, called with the tracestate of the parent. A span context can't
+// change once the span started, so fn is applied to the parent context the
+// span is started from; the samplers of the SDK carry it over to the child.
+// Results that don't form a valid tracestate are ignored.
+func WithTraceStateMutator(fn func(ts trace.TraceState) trace.TraceState) Option {
+	return optionFunc(func(c *config) {
+		c.traceStateMutator = fn
+	})
+}
+
+// WithLazySpanNaming defers starting the span to AfterProcess so that it can
+// be named after the statement, e.g. "SELECT users", without relying on
+// SDK support for renaming spans. The start timestamp is still taken in
+// BeforeProcess, so the span covers the whole query, but it is not in the
+// context while the query runs: spans created by the driver won't nest under
+// it, and it is not visible in live views until the query completes.
+func WithLazySpanNaming() Option {
+	return optionFunc(func(c *config) {
+		c.lazySpanNaming = true
+	})
+}
+
+// WithRecordArgs records each bound argument as a db.arg.<n> attribute, n
+// being its 1-based position, or db.arg.<name> for sql.Named arguments.
+func WithRecordArgs() Option {
+	return optionFunc(func(c *config) {
+		c.recordArgs = true
+	})
+}
+
+// WithRecordArgsAsJSON records the bound arguments as a single db.args
+// attribute holding a JSON array, more compact than WithRecordArgs for
+// backends that dislike many attributes. Elements are dropped from the end
+// rather than producing invalid JSON when WithMaxAttributeLength is exceeded.
+func WithRecordArgsAsJSON() Option {
+	return optionFunc(func(c *config) {
+		c.recordArgsJSON = true
+	})
+}
+
+// WithRecordArgValue records the argument bound to one placeholder as
+// db.arg.<name>, leaving the other arguments out, e.g. to expose a tenant ID
+// without recording every value. name is the name of an sql.Named argument or
+// the 1-based position of a positional one. It may be given several times.
+// Unless WithRecordArgs or WithRecordArgsAsJSON records every argument
+// anyway, db.statement holds the parameterized SQL as with WithRedactArgs.
+func WithRecordArgValue(name string) Option {
+	return optionFunc(func(c *config) {
+		if c.recordArgValues == nil {
+			c.recordArgValues = make(map[string]bool)
+		}
+		c.recordArgValues[name] = true
+	})
+}
+
+// WithRedactArgs keeps every bound argument out of spans, whatever other
+// options ask: WithRecordArgs, WithRecordArgsAsJSON, WithRecordArgValue and
+// WithRecordValuesOnConstraintError record nothing, and db.statement holds
+// the parameterized SQL as is, whatever the statement formatter.
+func WithRedactArgs() Option {
+	return optionFunc(func(c *config) {
+		c.redactArgs = true
+	})
+}
+
+// WithArgsRedactor replaces the bound arguments with fn's result wherever
+// they are recorded: in the statement formatter, WithRecordArgs,
+// WithRecordArgsAsJSON, WithRecordArgValue and
+// WithRecordValuesOnConstraintError. fn must not modify args in place, since
+// they are the arguments of the query. MaskArgs masks every value.
+func WithArgsRedactor(fn func(args []interface{}) []interface{}) Option {
+	return optionFunc(func(c *config) {
+		c.argsRedactor = fn
+	})
+}
+
+// MaskArgs is an arguments redactor for WithArgsRedactor replacing every
+// value with "***". The names of sql.Named arguments are kept.
+func MaskArgs(args []interface{}) []interface{} {
+	masked := make([]interface{}, len(args))
+	for i, arg := range args {
+		if named, ok := arg.(sql.NamedArg); ok {
+			masked[i] = sql.Named(named.Name, "***")
+			continue
+		}
+		masked[i] = "***"
+	}
+	return masked
+}
+
+// WithMaxRecordedArgs limits WithRecordArgs and WithRecordArgsAsJSON to the
+// first n arguments. Zero means no limit.
+func WithMaxRecordedArgs(n int) Option {
+	return optionFunc(func(c *config) {
+		c.maxArgs = n
+	})
+}
+
+// WithServerTimeFunc sets db.server.processing_ms to the server-side execution
+// time reported by fn, for drivers exposing it. Comparing it with the span
+// duration isolates the network overhead.
+func WithServerTimeFunc(fn func(c *contexts.ContextHook) (time.Duration, bool)) Option {
+	return optionFunc(func(c *config) {
+		c.serverTimeFunc = fn
+	})
+}
+
+// WithFilter drops the queries fn reports false for, e.g. health checks such
+// as "SELECT 1": no span is started and nothing is recorded for them, metrics
+// included. fn is called in BeforeProcess, or in AfterProcess if the SQL isn't
+// known before; the span then starts when the query starts, as with
+// WithLazySpanNaming.
+func WithFilter(fn func(sql string, args []interface{}) bool) Option {
+	return optionFunc(func(c *config) {
+		c.filter = fn
+	})
+}
+
+// WithErrorFilter sets the func reporting whether a query error makes the span
+// an error. Errors it reports false for are neither recorded nor set as the
+// span status, and count as successes in metrics. The default,
+// DefaultErrorFilter, ignores "no rows" errors.
+func WithErrorFilter(fn func(err error) bool) Option {
+	return optionFunc(func(c *config) {
+		c.errorFilter = fn
+	})
+}
+
+// WithErrorAttributes attaches attrs only to the spans of failed queries.
+func WithErrorAttributes(attrs ...attribute.KeyValue) Option {
+	return optionFunc(func(c *config) {
+		c.errorAttrs = append(c.errorAttrs, attrs...)
+	})
+}
+
+// WithBatchPositionFunc sets db.batch.index and db.batch.total from fn, for
+// statements the application runs as part of one logical batch.
+func WithBatchPositionFunc(fn func(ctx context.Context) (index, total int, ok bool)) Option {
+	return optionFunc(func(c *config) {
+		c.batchPositionFunc = fn
+	})
+}
+
+// WithReplicaInfoFunc sets db.replica and db.replica.lag_ms from fn, so that
+// possibly stale reads from a replica show up on spans.
+func WithReplicaInfoFunc(fn func(c *contexts.ContextHook) (name string, lagMs int64, ok bool)) Option {
+	return optionFunc(func(c *config) {
+		c.replicaInfoFunc = fn
+	})
+}
+
+// WithSlowThreshold flags queries running longer than d with a db.slow=true
+// attribute and a slow_query span event.
+func WithSlowThreshold(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.slowThreshold = d
+	})
+}
+
+// WithSlowThresholdByOperation sets slow query thresholds per statement type.
+// Statement types missing from thresholds use WithSlowThreshold.
+func WithSlowThresholdByOperation(thresholds map[StatementType]time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.slowThresholdByOperation = thresholds
+	})
+}
+
+// WithQueueTimeFunc sets db.queue_ms to the time a query waited in the
+// application, e.g. in a worker pool, before being handed to xorm.
+func WithQueueTimeFunc(fn func(ctx context.Context) (time.Duration, bool)) Option {
+	return optionFunc(func(c *config) {
+		c.queueTimeFunc = fn
+	})
+}
+
+// WithDialectNormalizer records the statement rewritten by n, e.g.
+// DefaultNormalizer, as db.statement.normalized. db.statement is unchanged.
+func WithDialectNormalizer(n Normalizer) Option {
+	return optionFunc(func(c *config) {
+		c.normalizer = n
+	})
+}
+
+// WithInheritParentSampling only records database spans whose parent span is
+// sampled, whether it is a local span or a remote span context. Queries
+// without a parent, or under an unsampled one, get a non-recording span, so
+// no orphan database spans are exported.
+func WithInheritParentSampling() Option {
+	return optionFunc(func(c *config) {
+		c.inheritParentSampling = true
+	})
+}
+
+// WithXormMethodFunc sets db.xorm.method to the ORM call, such as "Get" or
+// "Find", returned by fn. It can differ from the SQL verb: Get issues a
+// SELECT. xorm doesn't pass the method to hooks, so it has to come from the
+// caller, typically through a context value.
+func WithXormMethodFunc(fn func(c *contexts.ContextHook) (string, bool)) Option {
+	return optionFunc(func(c *config) {
+		c.xormMethodFunc = fn
+	})
+}
+
+// WithConnectionReusedFunc sets db.connection.reused from fn, to explain
+// latency spikes caused by new connections. The hook can't see the pool, so
+// this has to come from the caller.
+func WithConnectionReusedFunc(fn func(c *contexts.ContextHook) (reused bool, ok bool)) Option {
+	return optionFunc(func(c *config) {
+		c.connectionReusedFunc = fn
+	})
+}
+
+// WithStatementAttribute sets key to the value extractor derives from the
+// parsed statement. The statement is parsed once per query, however many
+// attributes are extracted. Empty values are omitted.
+func WithStatementAttribute(key attribute.Key, extractor func(parsed ParsedSQL) string) Option {
+	return optionFunc(func(c *config) {
+		c.statementAttrs = append(c.statementAttrs, statementAttribute{key: key, extractor: extractor})
+	})
+}
+
+// WithRecordExecPath sets db.xorm.exec to whether xorm ran the statement
+// through Exec rather than Query. Only Exec passes a result to the hook; when
+// a failed query has none, writes and DDL are assumed to have gone through
+// Exec.
+func WithRecordExecPath() Option {
+	return optionFunc(func(c *config) {
+		c.recordExecPath = true
+	})
+}
+
+// WithStructuredAttributes sets db.operation to the leading verb of the
+// statement and db.sql.table to its main table, so backends can aggregate by
+// operation without parsing db.statement. db.sql.table is omitted when the
+// statement isn't a SELECT, a write or DDL, e.g. BEGIN or PRAGMA, or when no
+// table is found.
+func WithStructuredAttributes() Option {
+	return optionFunc(func(c *config) {
+		c.structuredAttrs = true
+	})
+}
+
+// WithTableAttributes adds attrs[table] to the spans of the statements that
+// reference table, e.g. to mark queries on payments with sensitivity=high.
+// Keys match either the name of the table as written in the statement or its
+// unqualified name; a statement joining several configured tables gets the
+// attributes of each of them.
+func WithTableAttributes(attrs map[string][]attribute.KeyValue) Option {
+	return optionFunc(func(c *config) {
+		c.tableAttrs = attrs
+	})
+}
+
+// WithTxIDFunc sets db.transaction.id to the transaction ID the application
+// stored in the query context, so that all statements of a transaction can
+// be grouped.
+func WithTxIDFunc(fn func(ctx context.Context) (string, bool)) Option {
+	return optionFunc(func(c *config) {
+		c.txIDFunc = fn
+	})
+}
+
+// WithUpstreamCacheStatusFunc sets cache.status to the status, typically
+// "hit", "miss" or "bypass", of an application cache in front of the
+// database, so that queries running on a cache miss can be told apart.
+func WithUpstreamCacheStatusFunc(fn func(ctx context.Context) (string, bool)) Option {
+	return optionFunc(func(c *config) {
+		c.upstreamCacheStatusFunc = fn
+	})
+}
+
+// WithRecordLimit sets db.limit to the row limit of the statement, parsed from
+// LIMIT n, LIMIT offset, n, FETCH FIRST n ROWS or TOP n. Placeholders are
+// resolved from the arguments; the attribute is omitted when the value
+// can't be determined.
+func WithRecordLimit() Option {
+	return optionFunc(func(c *config) {
+		c.recordLimit = true
+	})
+}
+
+// WithRecordOffset sets db.offset to the row offset of the statement, parsed
+// from OFFSET n or LIMIT offset, n. Placeholders are resolved from the
+// arguments.
+func WithRecordOffset() Option {
+	return optionFunc(func(c *config) {
+		c.recordOffset = true
+	})
+}
+
+// WithFlagDeepPagination adds db.deep_pagination=true when the row offset of
+// the statement exceeds threshold. It implies WithRecordOffset.
+func WithFlagDeepPagination(threshold int) Option {
+	return optionFunc(func(c *config) {
+		c.recordOffset = true
+		c.deepPaginationThreshold = threshold
+	})
+}
+
+// WithRecordSortColumns sets db.sort.columns to the columns of the outermost
+// ORDER BY clause. Expressions are skipped rather than guessed.
+func WithRecordSortColumns() Option {
+	return optionFunc(func(c *config) {
+		c.recordSortColumns = true
+	})
+}
+
+// WithFlagIndexUnfriendly adds db.index_unfriendly=true to statements with a
+// predicate that usually prevents index use: a LIKE pattern starting with a
+// wildcard or a function call compared in a WHERE clause. Detection is a
+// best-effort heuristic.
+func WithFlagIndexUnfriendly() Option {
+	return optionFunc(func(c *config) {
+		c.flagIndexUnfriendly = true
+	})
+}
+
+// WithRecordJoinCount sets db.join.count to the number of JOIN clauses of the
+// statement, of any type. Words inside string literals are not counted.
+func WithRecordJoinCount() Option {
+	return optionFunc(func(c *config) {
+		c.recordJoinCount = true
+	})
+}
+
+// WithRecordIdempotency sets db.idempotent on every span. Only SELECT
+// statements are considered idempotent; use WithIdempotencyClassifier to
+// recognise more, such as updates keyed on a unique column.
+func WithRecordIdempotency() Option {
+	return WithIdempotencyClassifier(defaultIdempotencyClassifier)
+}
+
+// WithIdempotencyClassifier sets db.idempotent to the result of fn. It
+// implies WithRecordIdempotency.
+func WithIdempotencyClassifier(fn func(c *contexts.ContextHook, parsed ParsedSQL) bool) Option {
+	return optionFunc(func(c *config) {
+		c.idempotencyClassifier = fn
+	})
+}
+
+// WithFlagUnparameterized sets db.parameterized on SELECT, INSERT, UPDATE,
+// DELETE and REPLACE statements: false when the query has arguments but no
+// placeholder to bind them to, or no arguments and inlined string or
+// numeric literals other than LIMIT/OFFSET row counts. It may reveal
+// string-built SQL and an injection risk.
+func WithFlagUnparameterized() Option {
+	return optionFunc(func(c *config) {
+		c.flagUnparameterized = true
+	})
+}
+
+// WithStatementParser replaces the heuristic parser, e.g. with one backed by
+// a real SQL grammar when accuracy matters more than speed. When p fails the
+// default parser is used instead.
+func WithStatementParser(p StatementParser) Option {
+	return optionFunc(func(c *config) {
+		c.parser = p
+	})
+}
+
+// WithFingerprintCollisionDetection checks, for development, that distinct
+// statements don't share a fingerprint. A sample statement is kept per
+// fingerprint, up to a fixed bound; a statement colliding with a stored
+// different one gets db.fingerprint.collision=true and a
+// db.fingerprint.collision span event.
+func WithFingerprintCollisionDetection() Option {
+	return optionFunc(func(c *config) {
+		c.fingerprintSamples = &fingerprintSamples{}
+	})
+}
+
+// WithRecordPaginationStyle sets db.pagination.style on SELECT statements to
+// "cursor" for keyset pagination (WHERE id > ? ORDER BY id LIMIT ?),
+// "offset" for LIMIT/OFFSET pagination or "none".
+func WithRecordPaginationStyle() Option {
+	return optionFunc(func(c *config) {
+		c.recordPaginationStyle = true
+	})
+}
+
+// WithResultSizeFunc sets db.response.body.size to the result size in bytes
+// measured by the application, to find queries causing memory pressure.
+func WithResultSizeFunc(fn func(c *contexts.ContextHook) (bytes int64, ok bool)) Option {
+	return optionFunc(func(c *config) {
+		c.resultSizeFunc = fn
+	})
+}
+
+// WithRecordSiblingIndex sets db.sibling_query.index to the 1-based position
+// of the query among those run under the same parent span, within the scope
+// created by WithSiblingScope.
+func WithRecordSiblingIndex() Option {
+	return optionFunc(func(c *config) {
+		c.recordSiblingIndex = true
+	})
+}
+
+// WithHeartbeat adds a still_running event to the span of a running query
+// every interval, giving visibility into hung queries in live traces. The
+// heartbeat stops when the query completes, its context is done or after
+// 100 events. It is not available with WithLazySpanNaming, which has no span
+// while the query runs.
+func WithHeartbeat(interval time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.heartbeatInterval = interval
+	})
+}
+
+// WithDebugLogger logs the decisions of the hook with fn, such as whether a
+// parent span was found and whether the span is recording, to help find out
+// why a query has no span. log.Printf can be used as fn.
+func WithDebugLogger(fn func(format string, args ...interface{})) Option {
+	return optionFunc(func(c *config) {
+		c.debugLogger = fn
+	})
 }
 
 func WithBeforeHookHook(fn func(c *contexts.ContextHook)) Option {
@@ -76,6 +1004,192 @@ func WithAfterHook(fn func(c *contexts.ContextHook)) Option {
 	})
 }
 
+// serverVersion returns the cached server version, looking it up if it isn't
+// known yet. Lookups don't hold a lock, so that a lookup querying the hooked
+// engine doesn't deadlock: queries completing during a lookup skip it.
+func (c *config) serverVersion() (string, bool) {
+	if c.dbVersionFunc == nil {
+		return "", false
+	}
+	if v, ok := c.dbVersion.Load().(string); ok {
+		return v, true
+	}
+	if !atomic.CompareAndSwapInt32(&c.dbVersionLookups, 0, 1) {
+		return "", false
+	}
+	defer atomic.StoreInt32(&c.dbVersionLookups, 0)
+	v, ok := c.dbVersionFunc()
+	if !ok || v == "" {
+		return "", false
+	}
+	c.dbVersion.Store(v)
+	return v, true
+}
+
+// currentSchemaVersion returns the cached schema version, looking it up again
+// if it expired.
+func (c *config) currentSchemaVersion() (string, bool) {
+	if c.schemaVersionFunc == nil {
+		return "", false
+	}
+	cached, ok := c.schemaVersion.Load().(schemaVersionEntry)
+	if ok && (c.schemaVersionTTL <= 0 || time.Since(cached.fetched) < c.schemaVersionTTL) {
+		return cached.version, true
+	}
+	// A single lookup at a time, outside any lock, so that fn may run
+	// queries through this hook. Queries meanwhile use the expired version,
+	// if any.
+	if !atomic.CompareAndSwapInt32(&c.schemaVersionLookups, 0, 1) {
+		return cached.version, ok
+	}
+	defer atomic.StoreInt32(&c.schemaVersionLookups, 0)
+	v, ok := c.schemaVersionFunc()
+	if !ok || v == "" {
+		// Not cached, so that the next query looks it up again.
+		return "", false
+	}
+	c.schemaVersion.Store(schemaVersionEntry{version: v, fetched: time.Now()})
+	return v, true
+}
+
+// schemaVersionEntry is a schema version looked up by WithSchemaVersionFunc.
+type schemaVersionEntry struct {
+	version string
+	fetched time.Time
+}
+
+// tableAttributes returns the WithTableAttributes attributes of tables.
+func (c *config) tableAttributes(tables []string) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, table := range tables {
+		kv, ok := c.tableAttrs[table]
+		if !ok {
+			kv = c.tableAttrs[lastSegment(table)]
+		}
+		attrs = append(attrs, kv...)
+	}
+	return attrs
+}
+
+// rowCount returns the db.rows count of hook for WithUnifiedRowCount.
+func (c *config) rowCount(hook *contexts.ContextHook, stmtType StatementType) (int64, bool) {
+	switch {
+	case stmtType.IsWrite() && hook.Result != nil:
+		n, err := hook.Result.RowsAffected()
+		return n, err == nil
+	case stmtType == StatementSelect && c.readCountFunc != nil:
+		return c.readCountFunc(hook)
+	}
+	return 0, false
+}
+
+// slowThresholdFor returns the slow query threshold of t, zero if there is
+// none.
+func (c *config) slowThresholdFor(t StatementType) time.Duration {
+	if d, ok := c.slowThresholdByOperation[t]; ok {
+		return d
+	}
+	return c.slowThreshold
+}
+
+// formatsStatement reports whether db.statement is rendered by the
+// statement formatter. With WithRedactArgs, or WithRecordArgValue alone,
+// db.statement holds the parameterized SQL instead: WithRecordArgValue
+// records the selected values only, and the formatter would emit every one.
+func (c *config) formatsStatement() bool {
+	selectedArgsOnly := len(c.recordArgValues) > 0 && !c.recordArgs && !c.recordArgsJSON
+	return !c.disableStatement && !c.redactArgs && !selectedArgsOnly
+}
+
+// statementFormatter returns the statement formatter of t.
+func (c *config) statementFormatter(t StatementType) func(sql string, args []interface{}) string {
+	if f, ok := c.formatSQLByOperation[t]; ok {
+		return f
+	}
+	return c.formatSQL
+}
+
+func defaultIdempotencyClassifier(_ *contexts.ContextHook, parsed ParsedSQL) bool {
+	return parsed.Operation == StatementSelect
+}
+
+// truncate shortens s to at most c.maxAttributeLength bytes without
+// splitting a UTF-8 sequence.
+func (c *config) truncate(s string) string {
+	if c.maxAttributeLength <= 0 || len(s) <= c.maxAttributeLength {
+		return s
+	}
+	n := c.maxAttributeLength
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func (c *config) errorType(err error) string {
+	if c.unwrapErrorType {
+		for inner := errors.Unwrap(err); inner != nil; inner = errors.Unwrap(err) {
+			err = inner
+		}
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+// recordedArgs returns the args recorded as attributes, honouring
+// WithMaxRecordedArgs.
+func (c *config) recordedArgs(args []interface{}) []interface{} {
+	if c.maxArgs > 0 && len(args) > c.maxArgs {
+		return args[:c.maxArgs]
+	}
+	return args
+}
+
+// argAttributes returns the db.arg.* attributes of args.
+func (c *config) argAttributes(args []interface{}) []attribute.KeyValue {
+	args = c.recordedArgs(args)
+	attrs := make([]attribute.KeyValue, 0, len(args))
+	for i, arg := range args {
+		key := strconv.Itoa(i + 1)
+		if named, ok := arg.(sql.NamedArg); ok {
+			key, arg = named.Name, named.Value
+		}
+		attrs = append(attrs, attribute.Key("db.arg."+key).String(c.truncate(c.valueFormat.formatValue(arg))))
+	}
+	return attrs
+}
+
+// argValueAttributes returns the db.arg.* attributes of the args selected by
+// WithRecordArgValue.
+func (c *config) argValueAttributes(args []interface{}) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for i, arg := range args {
+		pos, key := strconv.Itoa(i+1), strconv.Itoa(i+1)
+		if named, ok := arg.(sql.NamedArg); ok {
+			key, arg = named.Name, named.Value
+		}
+		if c.recordArgValues[key] || c.recordArgValues[pos] {
+			attrs = append(attrs, attribute.Key("db.arg."+key).String(c.truncate(c.valueFormat.formatValue(arg))))
+		}
+	}
+	return attrs
+}
+
+// argsJSON returns the db.args attribute of args.
+func (c *config) argsJSON(args []interface{}) attribute.KeyValue {
+	args = c.recordedArgs(args)
+	values := make([]string, len(args))
+	for i, arg := range args {
+		values[i] = c.valueFormat.formatValue(arg)
+	}
+	for {
+		data, _ := json.Marshal(values)
+		if c.maxAttributeLength <= 0 || len(data) <= c.maxAttributeLength || len(values) == 0 {
+			return attribute.Key("db.args").String(string(data))
+		}
+		values = values[:len(values)-1]
+	}
+}
+
 func defaultFormatSQL(sql string, args []interface{}) string {
 	argsStr := fmt.Sprintf("%v", args)
 	m, err := json.Marshal(args)
@@ -85,57 +1199,137 @@ func defaultFormatSQL(sql string, args []interface{}) string {
 	return fmt.Sprintf("%v %v", sql, argsStr)
 }
 
-func formatSQLReplace(sql string, args []interface{}) string {
+// formatSQLReplace replaces the placeholders of sql with the formatted args.
+// Both ? and $N placeholders are recognised, so the same pass works for
+// MySQL, SQLite and PostgreSQL; placeholders inside string literals, quoted
+// identifiers and comments are left alone.
+func (f valueFormat) formatSQLReplace(sql string, args []interface{}) string {
 	if len(args) == 0 {
 		return sql
 	}
 
-	re := regexp.MustCompile(`\$\d+`)
-	matches := re.FindAllStringIndex(sql, -1)
-
-	if len(matches) == 0 {
-		// 如果没有找到占位符，但提供了参数，我们将参数添加到SQL语句的末尾
-		return fmt.Sprintf("%s /* Unused args: %v */", sql, args)
-	}
-
 	var sb strings.Builder
 	lastIndex := 0
-	argIndex := 0
+	nextArg := 0
+	usedArgs := 0
 
-	for _, match := range matches {
-		sb.WriteString(sql[lastIndex:match[0]])
-
-		if argIndex < len(args) {
-			sb.WriteString(formatValue(args[argIndex]))
-			argIndex++
-		} else {
-			// 如果参数不足，保留原始占位符
-			sb.WriteString(sql[match[0]:match[1]])
+	for i := 0; i < len(sql); {
+		argIndex := -1
+		end := i + 1
+		switch ch := sql[i]; {
+		case ch == '\'' || ch == '"' || ch == '`':
+			i = quotedEnd(sql, i, ch)
+			continue
+		case strings.HasPrefix(sql[i:], "--"):
+			if e := strings.IndexByte(sql[i:], '\n'); e >= 0 {
+				i += e + 1
+			} else {
+				i = len(sql)
+			}
+			continue
+		case strings.HasPrefix(sql[i:], "/*"):
+			if e := strings.Index(sql[i+2:], "*/"); e >= 0 {
+				i += e + 4
+			} else {
+				i = len(sql)
+			}
+			continue
+		case ch == '?':
+			argIndex = nextArg
+			nextArg++
+		case ch == '$' && end < len(sql) && sql[end] >= '0' && sql[end] <= '9':
+			for end < len(sql) && sql[end] >= '0' && sql[end] <= '9' {
+				end++
+			}
+			n, _ := strconv.Atoi(sql[i+1 : end])
+			argIndex = n - 1
+		default:
+			i++
+			continue
 		}
 
-		lastIndex = match[1]
+		// 如果参数不足，保留原始占位符
+		if argIndex >= 0 && argIndex < len(args) {
+			sb.WriteString(sql[lastIndex:i])
+			sb.WriteString(f.formatPlaceholderValue(args[argIndex]))
+			lastIndex = end
+			if argIndex >= usedArgs {
+				usedArgs = argIndex + 1
+			}
+		}
+		i = end
 	}
 
 	sb.WriteString(sql[lastIndex:])
 
 	// 如果还有未使用的参数，将它们作为注释添加到SQL的末尾
-	if argIndex < len(args) {
-		sb.WriteString(fmt.Sprintf(" /* Unused args: %v */", args[argIndex:]))
+	if usedArgs < len(args) {
+		unused := make([]string, len(args)-usedArgs)
+		for i, arg := range args[usedArgs:] {
+			unused[i] = f.formatValue(arg)
+		}
+		sb.WriteString(fmt.Sprintf(" /* Unused args: [%s] */", strings.Join(unused, " ")))
 	}
 
 	return sb.String()
 }
 
-func formatValue(v interface{}) string {
+// timezoneName returns the IANA name of loc. time.Local is named after $TZ
+// or the target of /etc/localtime, the sources the time package loads it
+// from, and is "Local" if neither is available.
+func timezoneName(loc *time.Location) string {
+	if loc != time.Local {
+		return loc.String()
+	}
+	if tz, ok := os.LookupEnv("TZ"); ok {
+		if tz == "" {
+			return "UTC"
+		}
+		return zoneinfoName(strings.TrimPrefix(tz, ":"))
+	}
+	if target, err := os.Readlink("/etc/localtime"); err == nil {
+		return zoneinfoName(target)
+	}
+	return loc.String()
+}
+
+// zoneinfoName strips the zoneinfo directory from a zone file path.
+func zoneinfoName(path string) string {
+	if i := strings.LastIndex(path, "zoneinfo/"); i >= 0 {
+		return path[i+len("zoneinfo/"):]
+	}
+	return path
+}
+
+// formatPlaceholderValue formats an arg filling a placeholder. Only the
+// value of a sql.NamedArg is formatted, since name=value is not valid SQL
+// there.
+func (f valueFormat) formatPlaceholderValue(v interface{}) string {
+	if named, ok := v.(sql.NamedArg); ok {
+		v = named.Value
+	}
+	return f.formatValue(v)
+}
+
+func (f valueFormat) formatValue(v interface{}) string {
 	if v == nil {
 		return "NULL"
 	}
 	var data string
 	switch val := v.(type) {
+	case sql.NamedArg:
+		return val.Name + "=" + f.formatValue(val.Value)
 	case string:
 		data = val
 	case time.Time:
-		data = fmt.Sprintf("'%s'", val.Format("2006-01-02 15:04:05"))
+		if f.timeLocation != nil {
+			val = val.In(f.timeLocation)
+		}
+		layout := f.timeLayout
+		if layout == "" {
+			layout = defaultTimeLayout
+		}
+		data = val.Format(layout)
 	case []byte:
 		data = string(val)
 	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
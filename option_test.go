@@ -0,0 +1,1374 @@
+package otelxorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.18.0"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"xorm.io/xorm/contexts"
+)
+
+func TestAttributes(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		want     []attribute.KeyValue
+		wantName string
+	}{
+		{
+			name:     "custom",
+			opts:     []Option{WithAttributes(attribute.String("service.tier", "gold"), attribute.Int("shard", 3))},
+			want:     []attribute.KeyValue{attribute.String("service.tier", "gold"), attribute.Int("shard", 3)},
+			wantName: "xorm-db",
+		},
+		{
+			name:     "semconv",
+			opts:     []Option{WithAttributes(semconv.DBSystemSqlite, semconv.DBName("app"))},
+			want:     []attribute.KeyValue{semconv.DBSystemSqlite, semconv.DBName("app")},
+			wantName: "app",
+		},
+		{
+			name:     "appended to WithDBSystem",
+			opts:     []Option{WithDBSystem("mysql"), WithAttributes(attribute.String("shard", "a"))},
+			want:     []attribute.KeyValue{semconv.DBSystemKey.String("mysql"), attribute.String("shard", "a")},
+			wantName: "xorm-db",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: "SELECT 1"}, tt.opts...)
+			for _, kv := range tt.want {
+				if v, ok := attrValue(span.Attributes, kv.Key); !ok || v != kv.Value {
+					t.Errorf("%s = %q (present %v), want %q", kv.Key, v.Emit(), ok, kv.Value.Emit())
+				}
+			}
+			if span.Name != tt.wantName {
+				t.Errorf("span name = %q, want %q", span.Name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestDBVersion(t *testing.T) {
+	span := runQuery(t, testQuery{sql: "SELECT 1"}, WithDBVersion("8.0.36"))
+	if v, ok := attrValue(span.Attributes, "db.version"); !ok || v.AsString() != "8.0.36" {
+		t.Errorf("db.version = %q, want 8.0.36", v.Emit())
+	}
+
+	span = runQuery(t, testQuery{sql: "SELECT 1"}, WithDBVersion(""))
+	if _, ok := attrValue(span.Attributes, "db.version"); ok {
+		t.Error("db.version is set for an empty static version")
+	}
+}
+
+func TestDBVersionFuncCached(t *testing.T) {
+	calls := 0
+	h, exp := newTestHook(WithDBVersionFunc(func() (string, bool) {
+		calls++
+		return "15.4", true
+	}))
+	for i := 0; i < 3; i++ {
+		testQuery{sql: "SELECT 1"}.run(t, h)
+	}
+	if calls != 1 {
+		t.Errorf("version func called %d times, want 1", calls)
+	}
+	for _, span := range exp.GetSpans() {
+		if v, ok := attrValue(span.Attributes, "db.version"); !ok || v.AsString() != "15.4" {
+			t.Errorf("db.version = %q, want 15.4", v.Emit())
+		}
+	}
+}
+
+func TestDBVersionFuncRetriedAfterFailure(t *testing.T) {
+	calls := 0
+	h, exp := newTestHook(WithDBVersionFunc(func() (string, bool) {
+		calls++
+		return "15.4", calls > 1
+	}))
+	testQuery{sql: "SELECT 1"}.run(t, h)
+	testQuery{sql: "SELECT 1"}.run(t, h)
+	testQuery{sql: "SELECT 1"}.run(t, h)
+
+	spans := exp.GetSpans()
+	if _, ok := attrValue(spans[0].Attributes, "db.version"); ok {
+		t.Error("db.version is set although the lookup failed")
+	}
+	for _, span := range spans[1:] {
+		if v, ok := attrValue(span.Attributes, "db.version"); !ok || v.AsString() != "15.4" {
+			t.Errorf("db.version = %q, want 15.4", v.Emit())
+		}
+	}
+	if calls != 2 {
+		t.Errorf("version func called %d times, want 2", calls)
+	}
+}
+
+func TestDBVersionFuncQueryingThroughHook(t *testing.T) {
+	var h *OpenTelemetryHook
+	h, exp := newTestHook(WithDBVersionFunc(func() (string, bool) {
+		// As with engine.SQL("SELECT VERSION()").Get(&v) on the hooked engine.
+		testQuery{sql: "SELECT VERSION()"}.run(t, h)
+		return "8.0.36", true
+	}))
+	testQuery{sql: "SELECT 1"}.run(t, h)
+
+	spans := exp.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if _, ok := attrValue(spans[0].Attributes, "db.version"); ok {
+		t.Error("the version lookup query has db.version")
+	}
+	if v, ok := attrValue(spans[1].Attributes, "db.version"); !ok || v.AsString() != "8.0.36" {
+		t.Errorf("db.version = %q, want 8.0.36", v.Emit())
+	}
+}
+
+func TestSpanKindByOperation(t *testing.T) {
+	kinds := map[StatementType]trace.SpanKind{
+		StatementSelect: trace.SpanKindInternal,
+		StatementInsert: trace.SpanKindProducer,
+	}
+	tests := []struct {
+		sql  string
+		want trace.SpanKind
+	}{
+		{"SELECT 1", trace.SpanKindInternal},
+		{"insert into t (a) values (?)", trace.SpanKindProducer},
+		{"UPDATE t SET a = 1", trace.SpanKindClient},
+		{"", trace.SpanKindClient},
+	}
+	for _, tt := range tests {
+		span := runQuery(t, testQuery{sql: tt.sql}, WithSpanKindByOperation(kinds))
+		if span.SpanKind != tt.want {
+			t.Errorf("%q: span kind = %v, want %v", tt.sql, span.SpanKind, tt.want)
+		}
+	}
+}
+
+func TestMassMutationThreshold(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		affected int64
+		want     bool
+	}{
+		{"below", "UPDATE users SET active = 0", 99, false},
+		{"at", "UPDATE users SET active = 0", 100, false},
+		{"above", "DELETE FROM users", 101, true},
+		{"select above", "SELECT * FROM users", 101, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql, result: rowsAffected(tt.affected)}, WithMassMutationThreshold(100))
+			v, ok := attrValue(span.Attributes, "db.mass_mutation")
+			if ok != tt.want || ok && !v.AsBool() {
+				t.Errorf("db.mass_mutation = %v (present: %t), want present: %t", v.Emit(), ok, tt.want)
+			}
+			event, ok := eventNamed(span, "mass_mutation")
+			if ok != tt.want {
+				t.Fatalf("mass_mutation event present: %t, want %t", ok, tt.want)
+			}
+			if ok {
+				if v, _ := attrValue(event.Attributes, "db.rows.affected"); v.AsInt64() != tt.affected {
+					t.Errorf("event db.rows.affected = %d, want %d", v.AsInt64(), tt.affected)
+				}
+			}
+		})
+	}
+}
+
+func TestBundle(t *testing.T) {
+	members := []Option{
+		WithDBName("orders"),
+		WithSpanName("first"),
+		WithSpanName("second"),
+		WithTagMigrations(),
+		WithSlowThreshold(time.Second),
+	}
+	individually := Hook(members...).(*OpenTelemetryHook).Config()
+	tests := []struct {
+		name   string
+		option Option
+	}{
+		{"bundle", Bundle(members...)},
+		{"options", Options(members)},
+		{"nested bundles", Bundle(Bundle(members[:2]...), Options(members[2:]))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Hook(tt.option).(*OpenTelemetryHook).Config()
+			if !reflect.DeepEqual(got, individually) {
+				t.Errorf("config = %+v, want %+v", got, individually)
+			}
+		})
+	}
+}
+
+func TestOptionsApply(t *testing.T) {
+	defaults := Options{WithDBName("default"), WithTagMigrations()}
+	extended := defaults.Apply(WithMigrationSpanName("migration"))
+	if len(defaults) != 2 {
+		t.Errorf("Apply modified the receiver: %d options, want 2", len(defaults))
+	}
+	cfg := Hook(extended).(*OpenTelemetryHook).Config()
+	if cfg.DBName != "default" || !cfg.TagMigrations || cfg.MigrationSpanName != "migration" {
+		t.Errorf("config = %+v, want the defaults and the migration span name", cfg)
+	}
+}
+
+func TestColumnCountFunc(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+		ok    bool
+	}{
+		{"reported", 7, true},
+		{"unknown", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: "SELECT * FROM users"}, WithColumnCountFunc(func(c *contexts.ContextHook) (int, bool) {
+				return tt.count, tt.ok
+			}))
+			v, ok := attrValue(span.Attributes, "db.columns.count")
+			if ok != tt.ok || v.AsInt64() != int64(tt.count) {
+				t.Errorf("db.columns.count = %q (present: %t), want %d (present: %t)", v.Emit(), ok, tt.count, tt.ok)
+			}
+		})
+	}
+
+	span := runQuery(t, testQuery{sql: "SELECT * FROM users"})
+	if _, ok := attrValue(span.Attributes, "db.columns.count"); ok {
+		t.Error("db.columns.count is set without a column count func")
+	}
+}
+
+func TestTimeLayout(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	at := time.Date(2024, 3, 1, 9, 30, 0, 0, tokyo)
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"default", nil, "SELECT * FROM t WHERE at = '2024-03-01 09:30:00'"},
+		{"utc", []Option{WithTimeLayoutUTC()}, "SELECT * FROM t WHERE at = '2024-03-01 00:30:00'"},
+		{"rfc3339", []Option{WithTimeLayout(time.RFC3339)}, "SELECT * FROM t WHERE at = '2024-03-01T09:30:00+09:00'"},
+		{"rfc3339 utc", []Option{WithTimeLayout(time.RFC3339), WithTimeLayoutUTC()}, "SELECT * FROM t WHERE at = '2024-03-01T00:30:00Z'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithFormatSQLReplace()}, tt.opts...)
+			span := runQuery(t, testQuery{sql: "SELECT * FROM t WHERE at = ?", args: []interface{}{at}}, opts...)
+			if v, _ := attrValue(span.Attributes, semconv.DBStatementKey); v.AsString() != tt.want {
+				t.Errorf("db.statement = %q, want %q", v.AsString(), tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryPlanFunc(t *testing.T) {
+	plan := "Seq Scan on users  (cost=0.00..35.50 rows=2550 width=4)"
+	planFunc := WithQueryPlanFunc(func(c *contexts.ContextHook) (string, bool) {
+		return plan, c.SQL != "SELECT 1"
+	})
+
+	span := runQuery(t, testQuery{sql: "SELECT * FROM users"}, planFunc)
+	event, ok := eventNamed(span, "db.query.plan")
+	if !ok {
+		t.Fatal("no db.query.plan event")
+	}
+	if v, _ := attrValue(event.Attributes, "db.query.plan"); v.AsString() != plan {
+		t.Errorf("plan = %q, want %q", v.AsString(), plan)
+	}
+	if _, ok := attrValue(span.Attributes, "db.query.plan"); ok {
+		t.Error("the plan is recorded as a span attribute")
+	}
+
+	span = runQuery(t, testQuery{sql: "SELECT * FROM users"}, planFunc, WithMaxAttributeLength(8))
+	event, _ = eventNamed(span, "db.query.plan")
+	if v, _ := attrValue(event.Attributes, "db.query.plan"); v.AsString() != plan[:8] {
+		t.Errorf("truncated plan = %q, want %q", v.AsString(), plan[:8])
+	}
+
+	span = runQuery(t, testQuery{sql: "SELECT 1"}, planFunc)
+	if _, ok := eventNamed(span, "db.query.plan"); ok {
+		t.Error("db.query.plan event recorded although the func reported no plan")
+	}
+}
+
+func TestMaxAttributeLength(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		sql  string
+		want string
+	}{
+		{"unlimited", 0, "SELECT * FROM users", "SELECT * FROM users"},
+		{"truncated", 8, "SELECT * FROM users", "SELECT *"},
+		{"multi-byte rune kept whole", 9, "SELECT 'é'", "SELECT '"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql}, WithFormatSQLReplace(), WithMaxAttributeLength(tt.max))
+			if v, _ := attrValue(span.Attributes, semconv.DBStatementKey); v.AsString() != tt.want {
+				t.Errorf("db.statement = %q, want %q", v.AsString(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHostname(t *testing.T) {
+	auto, err := os.Hostname()
+	if err != nil {
+		t.Skipf("os.Hostname: %v", err)
+	}
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"none", nil, ""},
+		{"static", []Option{WithHostname("web-1")}, "web-1"},
+		{"auto", []Option{WithAutoHostname()}, auto},
+		{"static wins over auto", []Option{WithAutoHostname(), WithHostname("web-1")}, "web-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: "SELECT 1"}, tt.opts...)
+			v, ok := attrValue(span.Attributes, semconv.HostNameKey)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("host.name = %q, want none", v.AsString())
+				}
+				return
+			}
+			if v.AsString() != tt.want {
+				t.Errorf("host.name = %q, want %q", v.AsString(), tt.want)
+			}
+		})
+	}
+}
+
+// driverError stands for a driver's error type, such as *mysql.MySQLError.
+type driverError struct{ code int }
+
+func (e *driverError) Error() string { return fmt.Sprintf("driver error %d", e.code) }
+
+func TestRecordErrorType(t *testing.T) {
+	drvErr := &driverError{code: 1062}
+	opErr := &net.OpError{Op: "dial", Net: "tcp", Err: drvErr}
+	wrapped := fmt.Errorf("query users: %w", opErr)
+	tests := []struct {
+		name string
+		opts []Option
+		err  error
+		want string
+	}{
+		{"unwrapped", []Option{WithRecordErrorType()}, opErr, "*net.OpError"},
+		{"wrapped", []Option{WithRecordErrorType()}, wrapped, "*fmt.wrapError"},
+		{"innermost of unwrapped", []Option{WithRecordInnermostErrorType()}, drvErr, "*otelxorm.driverError"},
+		{"innermost of wrapped", []Option{WithRecordInnermostErrorType()}, fmt.Errorf("query users: %w", drvErr), "*otelxorm.driverError"},
+		{"innermost of doubly wrapped", []Option{WithRecordInnermostErrorType()}, wrapped, "*otelxorm.driverError"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: "SELECT * FROM users", err: tt.err}, tt.opts...)
+			if v, _ := attrValue(span.Attributes, "db.error.type"); v.AsString() != tt.want {
+				t.Errorf("db.error.type = %q, want %q", v.AsString(), tt.want)
+			}
+		})
+	}
+
+	span := runQuery(t, testQuery{sql: "SELECT * FROM users"}, WithRecordErrorType())
+	if _, ok := attrValue(span.Attributes, "db.error.type"); ok {
+		t.Error("db.error.type recorded for a successful query")
+	}
+}
+
+func TestConditionalAttributes(t *testing.T) {
+	isUpdate := func(c *contexts.ContextHook) bool {
+		return statementTypeOf(c.SQL) == StatementUpdate
+	}
+	isUsers := func(c *contexts.ContextHook) bool {
+		return strings.Contains(c.SQL, "users")
+	}
+	opts := []Option{
+		WithConditionalAttributes(isUpdate, attribute.Bool("app.write", true), attribute.String("app.audit", "on")),
+		WithConditionalAttributes(isUsers, attribute.String("app.table", "users")),
+	}
+	tests := []struct {
+		sql  string
+		want map[attribute.Key]string
+	}{
+		{"UPDATE users SET name = ?", map[attribute.Key]string{"app.write": "true", "app.audit": "on", "app.table": "users"}},
+		{"UPDATE orders SET paid = ?", map[attribute.Key]string{"app.write": "true", "app.audit": "on"}},
+		{"SELECT * FROM users", map[attribute.Key]string{"app.table": "users"}},
+		{"SELECT * FROM orders", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql}, opts...)
+			for _, key := range []attribute.Key{"app.write", "app.audit", "app.table"} {
+				v, ok := attrValue(span.Attributes, key)
+				want, wantOK := tt.want[key]
+				if ok != wantOK || (ok && v.Emit() != want) {
+					t.Errorf("%s = %q (present %v), want %q (present %v)", key, v.Emit(), ok, want, wantOK)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordNamedArgs(t *testing.T) {
+	args := []interface{}{"bob", sql.Named("age", 42), sql.Named("city", "Paris")}
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantAttrs map[attribute.Key]string
+		wantStmt  string
+	}{
+		{
+			name:      "record args",
+			opts:      []Option{WithRecordArgs()},
+			wantAttrs: map[attribute.Key]string{"db.arg.1": "'bob'", "db.arg.age": "'42'", "db.arg.city": "'Paris'"},
+			wantStmt:  "SELECT * FROM users WHERE name = 'bob' AND age = @age AND city = @city /* Unused args: [age='42' city='Paris'] */",
+		},
+		{
+			name:      "masked",
+			opts:      []Option{WithRecordArgs(), WithArgsRedactor(MaskArgs)},
+			wantAttrs: map[attribute.Key]string{"db.arg.1": "'***'", "db.arg.age": "'***'", "db.arg.city": "'***'"},
+			wantStmt:  "SELECT * FROM users WHERE name = '***' AND age = @age AND city = @city /* Unused args: [age='***' city='***'] */",
+		},
+		{
+			name:     "redacted",
+			opts:     []Option{WithRecordArgs(), WithRedactArgs()},
+			wantStmt: "SELECT * FROM users WHERE name = ? AND age = @age AND city = @city",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithFormatSQLReplace()}, tt.opts...)
+			span := runQuery(t, testQuery{sql: "SELECT * FROM users WHERE name = ? AND age = @age AND city = @city", args: args}, opts...)
+			for _, key := range []attribute.Key{"db.arg.1", "db.arg.age", "db.arg.city", "db.arg.2", "db.arg.3"} {
+				v, ok := attrValue(span.Attributes, key)
+				want, wantOK := tt.wantAttrs[key]
+				if ok != wantOK || (ok && v.AsString() != want) {
+					t.Errorf("%s = %q (present %v), want %q (present %v)", key, v.AsString(), ok, want, wantOK)
+				}
+			}
+			if v, _ := attrValue(span.Attributes, semconv.DBStatementKey); v.AsString() != tt.wantStmt {
+				t.Errorf("db.statement = %q, want %q", v.AsString(), tt.wantStmt)
+			}
+		})
+	}
+}
+
+func TestRecordArgValue(t *testing.T) {
+	args := []interface{}{"bob", "secret", sql.Named("tenant", 7)}
+	query := "SELECT * FROM users WHERE name = ? AND password = ? AND tenant = @tenant"
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantAttrs map[attribute.Key]string
+		wantStmt  string
+	}{
+		{
+			name:      "named",
+			opts:      []Option{WithRecordArgValue("tenant")},
+			wantAttrs: map[attribute.Key]string{"db.arg.tenant": "'7'"},
+			wantStmt:  query,
+		},
+		{
+			name:      "positional",
+			opts:      []Option{WithRecordArgValue("1"), WithRecordArgValue("3")},
+			wantAttrs: map[attribute.Key]string{"db.arg.1": "'bob'", "db.arg.tenant": "'7'"},
+			wantStmt:  query,
+		},
+		{
+			name:      "with record args",
+			opts:      []Option{WithRecordArgValue("tenant"), WithRecordArgs()},
+			wantAttrs: map[attribute.Key]string{"db.arg.1": "'bob'", "db.arg.2": "'secret'", "db.arg.tenant": "'7'"},
+			wantStmt:  "SELECT * FROM users WHERE name = 'bob' AND password = 'secret' AND tenant = @tenant /* Unused args: [tenant='7'] */",
+		},
+		{
+			name:     "redacted",
+			opts:     []Option{WithRecordArgValue("tenant"), WithRedactArgs()},
+			wantStmt: query,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithFormatSQLReplace()}, tt.opts...)
+			span := runQuery(t, testQuery{sql: query, args: args}, opts...)
+			for _, key := range []attribute.Key{"db.arg.1", "db.arg.2", "db.arg.3", "db.arg.tenant"} {
+				v, ok := attrValue(span.Attributes, key)
+				want, wantOK := tt.wantAttrs[key]
+				if ok != wantOK || (ok && v.AsString() != want) {
+					t.Errorf("%s = %q (present %v), want %q (present %v)", key, v.AsString(), ok, want, wantOK)
+				}
+			}
+			if v, _ := attrValue(span.Attributes, semconv.DBStatementKey); v.AsString() != tt.wantStmt {
+				t.Errorf("db.statement = %q, want %q", v.AsString(), tt.wantStmt)
+			}
+		})
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	const password = "hunter2"
+	args := []interface{}{"bob@example.com", password}
+	query := "UPDATE users SET password = ? WHERE email = ?"
+	custom := WithFormatSQL(func(sql string, args []interface{}) string {
+		return fmt.Sprintf("%s -- %v", sql, args)
+	})
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantStmt string
+	}{
+		{"default formatter masked", []Option{WithArgsRedactor(MaskArgs)}, query + ` ["***","***"]`},
+		{"replace formatter masked", []Option{WithFormatSQLReplace(), WithArgsRedactor(MaskArgs)}, "UPDATE users SET password = '***' WHERE email = '***'"},
+		{"custom formatter masked", []Option{custom, WithArgsRedactor(MaskArgs)}, query + " -- [*** ***]"},
+		{"default formatter redacted", []Option{WithRedactArgs()}, query},
+		{"replace formatter redacted", []Option{WithFormatSQLReplace(), WithRedactArgs()}, query},
+		{"custom formatter redacted", []Option{custom, WithRedactArgs()}, query},
+		{"redacted wins over the redactor and recorded args", []Option{WithArgsRedactor(MaskArgs), WithRecordArgs(), WithRecordArgsAsJSON(), WithRedactArgs()}, query},
+		{"redactor applies to recorded args", []Option{WithArgsRedactor(MaskArgs), WithRecordArgs(), WithRecordArgsAsJSON(), WithFormatSQLReplace()}, "UPDATE users SET password = '***' WHERE email = '***'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: query, args: args}, tt.opts...)
+			if v, _ := attrValue(span.Attributes, semconv.DBStatementKey); v.AsString() != tt.wantStmt {
+				t.Errorf("db.statement = %q, want %q", v.AsString(), tt.wantStmt)
+			}
+			for _, kv := range span.Attributes {
+				if strings.Contains(kv.Value.Emit(), password) {
+					t.Errorf("%s = %q leaks the password", kv.Key, kv.Value.Emit())
+				}
+			}
+			for _, event := range span.Events {
+				for _, kv := range event.Attributes {
+					if strings.Contains(kv.Value.Emit(), password) {
+						t.Errorf("event %s: %s = %q leaks the password", event.Name, kv.Key, kv.Value.Emit())
+					}
+				}
+			}
+		})
+	}
+	if args[1] != password {
+		t.Errorf("args[1] = %v, the redactor modified the query arguments", args[1])
+	}
+}
+
+func TestDisableDBStatement(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []Option
+		wantCollision bool
+	}{
+		{"default", nil, false},
+		{"replace formatter", []Option{WithFormatSQLReplace()}, false},
+		{"normalizer", []Option{WithDialectNormalizer(DefaultNormalizer)}, false},
+		{"fingerprint collision", []Option{WithFingerprintCollisionDetection()}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, exp := newTestHook(append([]Option{WithDisableDBStatement()}, tt.opts...)...)
+			h.config.fingerprint = func(string) string { return "stub" }
+			formatted := false
+			formatSQL := h.config.formatSQL
+			h.config.formatSQL = func(sql string, args []interface{}) string {
+				formatted = true
+				return formatSQL(sql, args)
+			}
+
+			testQuery{sql: "SELECT * FROM users"}.run(t, h)
+			testQuery{sql: "SELECT * FROM orders WHERE id = ?", args: []interface{}{1}, err: errors.New("boom")}.run(t, h)
+
+			spans := exp.GetSpans()
+			if len(spans) != 2 {
+				t.Fatalf("got %d spans, want 2", len(spans))
+			}
+			if formatted {
+				t.Error("statement formatter called")
+			}
+			for _, span := range spans {
+				for _, kv := range span.Attributes {
+					if strings.Contains(kv.Value.Emit(), "FROM") {
+						t.Errorf("%s = %q records the SQL", kv.Key, kv.Value.Emit())
+					}
+				}
+				for _, event := range span.Events {
+					for _, kv := range event.Attributes {
+						if strings.Contains(kv.Value.Emit(), "FROM") {
+							t.Errorf("event %s: %s = %q records the SQL", event.Name, kv.Key, kv.Value.Emit())
+						}
+					}
+				}
+			}
+			if spans[1].Status.Code != codes.Error {
+				t.Errorf("status = %v, want the error status kept", spans[1].Status.Code)
+			}
+			event, ok := eventNamed(spans[1], "db.fingerprint.collision")
+			if ok != tt.wantCollision {
+				t.Fatalf("collision event present %v, want %v", ok, tt.wantCollision)
+			}
+			if !ok {
+				return
+			}
+			if v, _ := attrValue(event.Attributes, "db.fingerprint"); v.AsString() != "stub" {
+				t.Errorf("db.fingerprint = %q, want stub", v.AsString())
+			}
+			if _, ok := attrValue(event.Attributes, "db.fingerprint.sample"); ok {
+				t.Error("db.fingerprint.sample recorded with WithDisableDBStatement")
+			}
+		})
+	}
+}
+
+func TestRowCounts(t *testing.T) {
+	countFive := WithRowsReturnedFunc(func(*contexts.ContextHook) (int64, bool) { return 5, true })
+	tests := []struct {
+		name  string
+		opts  []Option
+		query testQuery
+		// wantAffected and wantReturned are -1 if the attribute is omitted.
+		wantAffected int64
+		wantReturned int64
+	}{
+		{"insert", []Option{countFive}, testQuery{sql: "INSERT INTO users (name) VALUES (?)", args: []interface{}{"bob"}, result: rowsAffected(1)}, 1, -1},
+		{"update affecting none", nil, testQuery{sql: "UPDATE users SET name = ?", args: []interface{}{"bob"}, result: rowsAffected(0)}, 0, -1},
+		{"rows affected unsupported", nil, testQuery{sql: "DELETE FROM users", result: driver.ResultNoRows}, -1, -1},
+		{"select", []Option{countFive}, testQuery{sql: "SELECT * FROM users"}, -1, 5},
+		{"select with a result", []Option{countFive}, testQuery{sql: "SELECT * FROM users", result: rowsAffected(0)}, -1, 5},
+		{"select without count", nil, testQuery{sql: "SELECT * FROM users"}, -1, -1},
+		{"ddl", []Option{countFive}, testQuery{sql: "CREATE TABLE users (id INT)", result: rowsAffected(0)}, -1, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, tt.query, tt.opts...)
+			for key, want := range map[attribute.Key]int64{"db.rows.affected": tt.wantAffected, "db.rows.returned": tt.wantReturned} {
+				v, ok := attrValue(span.Attributes, key)
+				if want < 0 {
+					if ok {
+						t.Errorf("%s = %d, want it omitted", key, v.AsInt64())
+					}
+					continue
+				}
+				if !ok || v.AsInt64() != want {
+					t.Errorf("%s = %d (present %v), want %d", key, v.AsInt64(), ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnifiedRowCount(t *testing.T) {
+	countFive := func(*contexts.ContextHook) (int64, bool) { return 5, true }
+	tests := []struct {
+		name   string
+		opts   []Option
+		query  testQuery
+		want   int64
+		wantOK bool
+	}{
+		{"select via callback", []Option{WithUnifiedRowCount(countFive)}, testQuery{sql: "SELECT * FROM users"}, 5, true},
+		{"select via WithRowsReturnedFunc", []Option{WithRowsReturnedFunc(countFive), WithUnifiedRowCount(nil)}, testQuery{sql: "SELECT * FROM users"}, 5, true},
+		{"select without callback", []Option{WithUnifiedRowCount(nil)}, testQuery{sql: "SELECT * FROM users"}, 0, false},
+		{"select count unknown", []Option{WithUnifiedRowCount(func(*contexts.ContextHook) (int64, bool) { return 0, false })}, testQuery{sql: "SELECT * FROM users"}, 0, false},
+		{"update via rows affected", []Option{WithUnifiedRowCount(countFive)}, testQuery{sql: "UPDATE users SET name = ?", args: []interface{}{"bob"}, result: rowsAffected(3)}, 3, true},
+		{"insert via rows affected", []Option{WithUnifiedRowCount(nil)}, testQuery{sql: "INSERT INTO users (name) VALUES (?)", args: []interface{}{"bob"}, result: rowsAffected(1)}, 1, true},
+		{"write without result", []Option{WithUnifiedRowCount(countFive)}, testQuery{sql: "DELETE FROM users", err: errors.New("boom")}, 0, false},
+		{"ddl", []Option{WithUnifiedRowCount(countFive)}, testQuery{sql: "CREATE TABLE users (id INT)", result: rowsAffected(0)}, 0, false},
+		{"disabled", []Option{WithRowsReturnedFunc(countFive)}, testQuery{sql: "SELECT * FROM users"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, tt.query, tt.opts...)
+			v, ok := attrValue(span.Attributes, "db.rows")
+			if ok != tt.wantOK || v.AsInt64() != tt.want {
+				t.Errorf("db.rows = %d (present %v), want %d (present %v)", v.AsInt64(), ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestServerTimeFunc(t *testing.T) {
+	serverTime := WithServerTimeFunc(func(c *contexts.ContextHook) (time.Duration, bool) {
+		return 1500 * time.Microsecond, c.Result != nil
+	})
+	tests := []struct {
+		name   string
+		result sql.Result
+		want   float64
+		wantOK bool
+	}{
+		{"reported", rowsAffected(1), 1.5, true},
+		{"not reported", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: "UPDATE users SET name = ?", result: tt.result, duration: 4 * time.Millisecond}, serverTime)
+			v, ok := attrValue(span.Attributes, "db.server.processing_ms")
+			if ok != tt.wantOK || v.AsFloat64() != tt.want {
+				t.Errorf("db.server.processing_ms = %v (present %v), want %v (present %v)", v.AsFloat64(), ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestErrorAttributes(t *testing.T) {
+	opt := WithErrorAttributes(attribute.String("alert", "db"), attribute.Bool("page", true))
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"error", errors.New("connection refused"), true},
+		{"success", nil, false},
+		{"filtered error", sql.ErrNoRows, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: "SELECT * FROM users", err: tt.err}, opt)
+			alert, ok := attrValue(span.Attributes, "alert")
+			if ok != tt.want || (ok && alert.AsString() != "db") {
+				t.Errorf("alert = %q (present %v), want present %v", alert.AsString(), ok, tt.want)
+			}
+			if _, ok := attrValue(span.Attributes, "page"); ok != tt.want {
+				t.Errorf("page present %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+type batchKey struct{}
+
+type batchPosition struct{ index, total int }
+
+func TestBatchPositionFunc(t *testing.T) {
+	opt := WithBatchPositionFunc(func(ctx context.Context) (int, int, bool) {
+		pos, ok := ctx.Value(batchKey{}).(batchPosition)
+		return pos.index, pos.total, ok
+	})
+	h, exp := newTestHook(opt)
+	for i := 0; i < 3; i++ {
+		ctx := context.WithValue(context.Background(), batchKey{}, batchPosition{index: i, total: 3})
+		testQuery{ctx: ctx, sql: "INSERT INTO users (name) VALUES (?)", args: []interface{}{i}}.run(t, h)
+	}
+	testQuery{sql: "SELECT * FROM users"}.run(t, h)
+
+	spans := exp.GetSpans()
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans, want 4", len(spans))
+	}
+	for i, span := range spans[:3] {
+		index, _ := attrValue(span.Attributes, "db.batch.index")
+		total, _ := attrValue(span.Attributes, "db.batch.total")
+		if index.AsInt64() != int64(i) || total.AsInt64() != 3 {
+			t.Errorf("span %d: db.batch.index = %d, db.batch.total = %d, want %d and 3", i, index.AsInt64(), total.AsInt64(), i)
+		}
+	}
+	if _, ok := attrValue(spans[3].Attributes, "db.batch.index"); ok {
+		t.Error("db.batch.index recorded outside a batch")
+	}
+}
+
+func TestReplicaInfoFunc(t *testing.T) {
+	opt := WithReplicaInfoFunc(func(c *contexts.ContextHook) (string, int64, bool) {
+		return "replica-2", 250, statementTypeOf(c.SQL) == StatementSelect
+	})
+	tests := []struct {
+		sql     string
+		replica string
+		lag     int64
+		ok      bool
+	}{
+		{"SELECT * FROM users", "replica-2", 250, true},
+		{"UPDATE users SET name = ?", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql}, opt)
+			replica, ok := attrValue(span.Attributes, "db.replica")
+			lag, lagOK := attrValue(span.Attributes, "db.replica.lag_ms")
+			if ok != tt.ok || lagOK != tt.ok || replica.AsString() != tt.replica || lag.AsInt64() != tt.lag {
+				t.Errorf("db.replica = %q, db.replica.lag_ms = %d (present %v), want %q, %d (present %v)",
+					replica.AsString(), lag.AsInt64(), ok, tt.replica, tt.lag, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRecordArgsAsJSON(t *testing.T) {
+	args := []interface{}{"bob", 42, sql.Named("city", `Pa"ris`)}
+	tests := []struct {
+		name   string
+		opts   []Option
+		want   []string
+		wantOK bool
+	}{
+		{"all args", nil, []string{"'bob'", "'42'", `city='Pa"ris'`}, true},
+		{"capped", []Option{WithMaxRecordedArgs(2)}, []string{"'bob'", "'42'"}, true},
+		{"max length drops whole elements", []Option{WithMaxAttributeLength(16)}, []string{"'bob'", "'42'"}, true},
+		{"masked", []Option{WithArgsRedactor(MaskArgs)}, []string{"'***'", "'***'", "city='***'"}, true},
+		{"redacted", []Option{WithRedactArgs()}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithRecordArgsAsJSON()}, tt.opts...)
+			span := runQuery(t, testQuery{sql: "SELECT * FROM users WHERE name = ? AND age = ? AND city = @city", args: args}, opts...)
+			v, ok := attrValue(span.Attributes, "db.args")
+			if ok != tt.wantOK {
+				t.Fatalf("db.args present %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			var got []string
+			if err := json.Unmarshal([]byte(v.AsString()), &got); err != nil {
+				t.Fatalf("db.args %q is not a JSON array of strings: %v", v.AsString(), err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("db.args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	span := runQuery(t, testQuery{sql: "SELECT * FROM users"}, WithRecordArgsAsJSON())
+	if _, ok := attrValue(span.Attributes, "db.args"); ok {
+		t.Error("db.args recorded for a query without args")
+	}
+}
+
+func TestSlowThresholdByOperation(t *testing.T) {
+	opts := []Option{
+		WithSlowThreshold(time.Second),
+		WithSlowThresholdByOperation(map[StatementType]time.Duration{
+			StatementSelect: 100 * time.Millisecond,
+			StatementUpdate: 500 * time.Millisecond,
+		}),
+	}
+	tests := []struct {
+		name          string
+		sql           string
+		duration      time.Duration
+		wantSlow      bool
+		wantThreshold float64
+	}{
+		{"select under", "SELECT * FROM users", 100 * time.Millisecond, false, 0},
+		{"select over", "SELECT * FROM users", 101 * time.Millisecond, true, 100},
+		{"update under select threshold", "UPDATE users SET name = ?", 300 * time.Millisecond, false, 0},
+		{"update over", "UPDATE users SET name = ?", 600 * time.Millisecond, true, 500},
+		{"unlisted uses the default", "DELETE FROM users", 900 * time.Millisecond, false, 0},
+		{"unlisted over the default", "DELETE FROM users", 1100 * time.Millisecond, true, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql, duration: tt.duration}, opts...)
+			if v, ok := attrValue(span.Attributes, "db.slow"); ok != tt.wantSlow || (ok && !v.AsBool()) {
+				t.Errorf("db.slow present %v, want %v", ok, tt.wantSlow)
+			}
+			event, ok := eventNamed(span, "slow_query")
+			if ok != tt.wantSlow {
+				t.Fatalf("slow_query event present %v, want %v", ok, tt.wantSlow)
+			}
+			if !ok {
+				return
+			}
+			if v, _ := attrValue(event.Attributes, "db.slow.threshold_ms"); v.AsFloat64() != tt.wantThreshold {
+				t.Errorf("db.slow.threshold_ms = %v, want %v", v.AsFloat64(), tt.wantThreshold)
+			}
+			if v, _ := attrValue(event.Attributes, "db.duration_ms"); v.AsFloat64() != durationMs(tt.duration) {
+				t.Errorf("db.duration_ms = %v, want %v", v.AsFloat64(), durationMs(tt.duration))
+			}
+		})
+	}
+}
+
+func TestSlowThresholdDisabled(t *testing.T) {
+	span := runQuery(t, testQuery{sql: "SELECT * FROM users", duration: time.Hour})
+	if _, ok := eventNamed(span, "slow_query"); ok {
+		t.Error("slow_query event recorded without a threshold")
+	}
+}
+
+type enqueuedKey struct{}
+
+func TestQueueTimeFunc(t *testing.T) {
+	opt := WithQueueTimeFunc(func(ctx context.Context) (time.Duration, bool) {
+		d, ok := ctx.Value(enqueuedKey{}).(time.Duration)
+		return d, ok
+	})
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		want   float64
+		wantOK bool
+	}{
+		{"queued", context.WithValue(context.Background(), enqueuedKey{}, 25*time.Millisecond), 25, true},
+		{"not queued", context.Background(), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{ctx: tt.ctx, sql: "SELECT * FROM users"}, opt)
+			v, ok := attrValue(span.Attributes, "db.queue_ms")
+			if ok != tt.wantOK || v.AsFloat64() != tt.want {
+				t.Errorf("db.queue_ms = %v (present %v), want %v (present %v)", v.AsFloat64(), ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestClientTimezone(t *testing.T) {
+	t.Setenv("TZ", "Asia/Tokyo")
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("no tz database: %v", err)
+	}
+	at := time.Date(2024, 3, 1, 0, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantZone string
+		wantStmt string
+	}{
+		{"location", []Option{WithClientTimezone(paris)}, "Europe/Paris", "SELECT '2024-03-01 01:30:00'"},
+		{"local", []Option{WithClientTimezone(nil)}, "Asia/Tokyo", "SELECT '" + at.In(time.Local).Format(defaultTimeLayout) + "'"},
+		{"UTC applied last wins", []Option{WithClientTimezone(paris), WithTimeLayoutUTC()}, "UTC", "SELECT '2024-03-01 00:30:00'"},
+		{"client timezone applied last wins", []Option{WithTimeLayoutUTC(), WithClientTimezone(paris)}, "Europe/Paris", "SELECT '2024-03-01 01:30:00'"},
+		{"not recorded", []Option{WithTimeLayoutUTC()}, "", "SELECT '2024-03-01 00:30:00'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithFormatSQLReplace()}, tt.opts...)
+			span := runQuery(t, testQuery{sql: "SELECT ?", args: []interface{}{at}}, opts...)
+			zone, ok := attrValue(span.Attributes, "db.client.timezone")
+			if ok != (tt.wantZone != "") || zone.AsString() != tt.wantZone {
+				t.Errorf("db.client.timezone = %q (present %v), want %q", zone.AsString(), ok, tt.wantZone)
+			}
+			if v, _ := attrValue(span.Attributes, semconv.DBStatementKey); v.AsString() != tt.wantStmt {
+				t.Errorf("db.statement = %q, want %q", v.AsString(), tt.wantStmt)
+			}
+		})
+	}
+}
+
+func TestTimezoneName(t *testing.T) {
+	tests := []struct {
+		tz   string
+		want string
+	}{
+		{"Europe/Paris", "Europe/Paris"},
+		{":America/New_York", "America/New_York"},
+		{"/usr/share/zoneinfo/Asia/Tokyo", "Asia/Tokyo"},
+		{"", "UTC"},
+	}
+	for _, tt := range tests {
+		t.Setenv("TZ", tt.tz)
+		if got := timezoneName(time.Local); got != tt.want {
+			t.Errorf("TZ=%q: timezoneName(time.Local) = %q, want %q", tt.tz, got, tt.want)
+		}
+	}
+}
+
+type xormMethodKey struct{}
+
+func TestXormMethodFunc(t *testing.T) {
+	opt := WithXormMethodFunc(func(c *contexts.ContextHook) (string, bool) {
+		method, ok := c.Ctx.Value(xormMethodKey{}).(string)
+		return method, ok
+	})
+	tests := []struct {
+		method string
+		sql    string
+	}{
+		{"Get", "SELECT * FROM users WHERE id = ? LIMIT 1"},
+		{"Find", "SELECT * FROM users"},
+		{"Insert", "INSERT INTO users (name) VALUES (?)"},
+		{"Exec", "UPDATE users SET name = ?"},
+		{"", "SELECT 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.method != "" {
+				ctx = context.WithValue(ctx, xormMethodKey{}, tt.method)
+			}
+			span := runQuery(t, testQuery{ctx: ctx, sql: tt.sql}, opt)
+			v, ok := attrValue(span.Attributes, "db.xorm.method")
+			if ok != (tt.method != "") || v.AsString() != tt.method {
+				t.Errorf("db.xorm.method = %q (present %v), want %q", v.AsString(), ok, tt.method)
+			}
+		})
+	}
+}
+
+func TestEmptyFormattedStatement(t *testing.T) {
+	tests := []struct {
+		name      string
+		formatted string
+		want      bool
+	}{
+		{"empty", "", false},
+		{"whitespace", " \n\t", false},
+		{"statement", "SELECT ?", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: "SELECT ?", args: []interface{}{1}}, WithFormatSQL(func(string, []interface{}) string {
+				return tt.formatted
+			}))
+			if _, ok := attrValue(span.Attributes, semconv.DBStatementKey); ok != tt.want {
+				t.Errorf("db.statement present %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectionReusedFunc(t *testing.T) {
+	tests := []struct {
+		name   string
+		reused bool
+		known  bool
+	}{
+		{"reused", true, true},
+		{"new connection", false, true},
+		{"unknown", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: "SELECT 1"}, WithConnectionReusedFunc(func(*contexts.ContextHook) (bool, bool) {
+				return tt.reused, tt.known
+			}))
+			v, ok := attrValue(span.Attributes, "db.connection.reused")
+			if ok != tt.known || v.AsBool() != tt.reused {
+				t.Errorf("db.connection.reused = %v (present %v), want %v (present %v)", v.AsBool(), ok, tt.reused, tt.known)
+			}
+		})
+	}
+}
+
+type txIDKey struct{}
+
+func TestTxIDFunc(t *testing.T) {
+	h, exp := newTestHook(WithTxIDFunc(func(ctx context.Context) (string, bool) {
+		id, ok := ctx.Value(txIDKey{}).(string)
+		return id, ok
+	}))
+	tx := context.WithValue(context.Background(), txIDKey{}, "tx-42")
+	for _, sql := range []string{"BEGIN", "UPDATE accounts SET balance = balance - ? WHERE id = ?", "UPDATE accounts SET balance = balance + ? WHERE id = ?", "COMMIT"} {
+		testQuery{ctx: tx, sql: sql}.run(t, h)
+	}
+	testQuery{sql: "SELECT * FROM accounts"}.run(t, h)
+
+	spans := exp.GetSpans()
+	if len(spans) != 5 {
+		t.Fatalf("got %d spans, want 5", len(spans))
+	}
+	for i, span := range spans[:4] {
+		if v, _ := attrValue(span.Attributes, "db.transaction.id"); v.AsString() != "tx-42" {
+			t.Errorf("span %d: db.transaction.id = %q, want tx-42", i, v.AsString())
+		}
+	}
+	if _, ok := attrValue(spans[4].Attributes, "db.transaction.id"); ok {
+		t.Error("db.transaction.id recorded outside the transaction")
+	}
+}
+
+type cacheStatusKey struct{}
+
+func TestUpstreamCacheStatusFunc(t *testing.T) {
+	opt := WithUpstreamCacheStatusFunc(func(ctx context.Context) (string, bool) {
+		status, ok := ctx.Value(cacheStatusKey{}).(string)
+		return status, ok
+	})
+	for _, status := range []string{"hit", "miss", "bypass", ""} {
+		t.Run(status, func(t *testing.T) {
+			ctx := context.Background()
+			if status != "" {
+				ctx = context.WithValue(ctx, cacheStatusKey{}, status)
+			}
+			span := runQuery(t, testQuery{ctx: ctx, sql: "SELECT * FROM users"}, opt)
+			v, ok := attrValue(span.Attributes, "cache.status")
+			if ok != (status != "") || v.AsString() != status {
+				t.Errorf("cache.status = %q (present %v), want %q", v.AsString(), ok, status)
+			}
+		})
+	}
+}
+
+func TestResultSizeFunc(t *testing.T) {
+	opt := WithResultSizeFunc(func(c *contexts.ContextHook) (int64, bool) {
+		return 4096, statementTypeOf(c.SQL) == StatementSelect
+	})
+	tests := []struct {
+		sql    string
+		want   int64
+		wantOK bool
+	}{
+		{"SELECT * FROM users", 4096, true},
+		{"DELETE FROM users", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql}, opt)
+			v, ok := attrValue(span.Attributes, "db.response.body.size")
+			if ok != tt.wantOK || v.AsInt64() != tt.want {
+				t.Errorf("db.response.body.size = %d (present %v), want %d (present %v)", v.AsInt64(), ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestFormatSQLByOperation(t *testing.T) {
+	redact := func(sql string, _ []interface{}) string { return sql }
+	tests := []struct {
+		name string
+		opts []Option
+		sql  string
+		want string
+	}{
+		{"select uses the fallback", []Option{WithFormatSQLReplace()}, "SELECT * FROM users WHERE id = ?", "SELECT * FROM users WHERE id = '7'"},
+		{"insert uses its formatter", []Option{WithFormatSQLReplace()}, "INSERT INTO users (id) VALUES (?)", "INSERT INTO users (id) VALUES (?)"},
+		{"default fallback", nil, "SELECT * FROM users WHERE id = ?", "SELECT * FROM users WHERE id = ? [7]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append(tt.opts, WithFormatSQLByOperation(map[StatementType]func(sql string, args []interface{}) string{
+				StatementInsert: redact,
+			}))
+			span := runQuery(t, testQuery{sql: tt.sql, args: []interface{}{7}}, opts...)
+			if v, _ := attrValue(span.Attributes, semconv.DBStatementKey); v.AsString() != tt.want {
+				t.Errorf("db.statement = %q, want %q", v.AsString(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaVersionFunc(t *testing.T) {
+	calls := 0
+	version := "20240301_add_users"
+	h, exp := newTestHook(WithSchemaVersionFunc(func() (string, bool) {
+		calls++
+		return version, version != ""
+	}))
+	testQuery{sql: "SELECT 1"}.run(t, h)
+	version = "20240302_add_orders"
+	testQuery{sql: "SELECT 2"}.run(t, h)
+
+	if calls != 1 {
+		t.Errorf("func called %d times, want 1", calls)
+	}
+	for i, span := range exp.GetSpans() {
+		if v, _ := attrValue(span.Attributes, "db.schema.version"); v.AsString() != "20240301_add_users" {
+			t.Errorf("span %d: db.schema.version = %q, want the first lookup", i, v.AsString())
+		}
+	}
+}
+
+func TestSchemaVersionTTL(t *testing.T) {
+	calls := 0
+	h, exp := newTestHook(WithSchemaVersionTTL(time.Millisecond), WithSchemaVersionFunc(func() (string, bool) {
+		calls++
+		return fmt.Sprintf("v%d", calls), true
+	}))
+	testQuery{sql: "SELECT 1"}.run(t, h)
+	time.Sleep(5 * time.Millisecond)
+	testQuery{sql: "SELECT 2"}.run(t, h)
+
+	spans := exp.GetSpans()
+	for i, want := range []string{"v1", "v2"} {
+		if v, _ := attrValue(spans[i].Attributes, "db.schema.version"); v.AsString() != want {
+			t.Errorf("span %d: db.schema.version = %q, want %q", i, v.AsString(), want)
+		}
+	}
+}
+
+func TestSchemaVersionFuncNotOK(t *testing.T) {
+	span := runQuery(t, testQuery{sql: "SELECT 1"}, WithSchemaVersionFunc(func() (string, bool) {
+		return "ignored", false
+	}))
+	if _, ok := attrValue(span.Attributes, "db.schema.version"); ok {
+		t.Error("db.schema.version recorded although the func reported false")
+	}
+}
+
+func TestSchemaVersionFuncRetriesFailures(t *testing.T) {
+	calls := 0
+	h, exp := newTestHook(WithSchemaVersionFunc(func() (string, bool) {
+		calls++
+		return "20240301_add_users", calls > 1
+	}))
+	testQuery{sql: "SELECT 1"}.run(t, h)
+	testQuery{sql: "SELECT 2"}.run(t, h)
+	testQuery{sql: "SELECT 3"}.run(t, h)
+
+	if calls != 2 {
+		t.Errorf("func called %d times, want 2", calls)
+	}
+	for i, want := range []string{"", "20240301_add_users", "20240301_add_users"} {
+		v, ok := attrValue(exp.GetSpans()[i].Attributes, "db.schema.version")
+		if ok != (want != "") || v.AsString() != want {
+			t.Errorf("span %d: db.schema.version = %q (present %v), want %q", i, v.AsString(), ok, want)
+		}
+	}
+}
+
+func TestSchemaVersionFuncQueryingThroughHook(t *testing.T) {
+	var h *OpenTelemetryHook
+	h, exp := newTestHook(WithSchemaVersionFunc(func() (string, bool) {
+		// As with a query for the latest migration on the hooked engine.
+		testQuery{sql: "SELECT MAX(version) FROM schema_migrations"}.run(t, h)
+		return "42", true
+	}))
+	testQuery{sql: "SELECT 1"}.run(t, h)
+
+	spans := exp.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if _, ok := attrValue(spans[0].Attributes, "db.schema.version"); ok {
+		t.Error("the schema version lookup query has db.schema.version")
+	}
+	if v, _ := attrValue(spans[1].Attributes, "db.schema.version"); v.AsString() != "42" {
+		t.Errorf("db.schema.version = %q, want 42", v.AsString())
+	}
+}
+
+func TestSchemaVersionConcurrent(t *testing.T) {
+	h, exp := newTestHook(WithSchemaVersionTTL(time.Microsecond), WithSchemaVersionFunc(func() (string, bool) {
+		return "42", true
+	}))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				h.config.currentSchemaVersion()
+			}
+		}()
+	}
+	wg.Wait()
+	testQuery{sql: "SELECT 1"}.run(t, h)
+	if v, _ := attrValue(exp.GetSpans()[0].Attributes, "db.schema.version"); v.AsString() != "42" {
+		t.Errorf("db.schema.version = %q, want 42", v.AsString())
+	}
+}
+
+func TestFormatSQLReplace(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		args []interface{}
+		want string
+	}{
+		{"question marks", "SELECT * FROM t WHERE a=? AND b=?", []interface{}{1, "x"}, "SELECT * FROM t WHERE a='1' AND b='x'"},
+		{"dollar", "SELECT * FROM t WHERE a=$1 AND b=$2", []interface{}{1, "x"}, "SELECT * FROM t WHERE a='1' AND b='x'"},
+		{"dollar reused and out of order", "SELECT * FROM t WHERE a=$2 OR b=$1 OR c=$2", []interface{}{1, 2}, "SELECT * FROM t WHERE a='2' OR b='1' OR c='2'"},
+		{"fewer args than placeholders", "SELECT * FROM t WHERE a=? AND b=?", []interface{}{1}, "SELECT * FROM t WHERE a='1' AND b=?"},
+		{"more args than placeholders", "SELECT * FROM t WHERE a=?", []interface{}{1, 2}, "SELECT * FROM t WHERE a='1' /* Unused args: ['2'] */"},
+		{"question mark in a string literal", "SELECT * FROM t WHERE a='?' AND b=?", []interface{}{1}, "SELECT * FROM t WHERE a='?' AND b='1'"},
+		{"escaped quote in a string literal", `SELECT * FROM t WHERE a='it''s ?' AND b='x\'?' AND c=?`, []interface{}{1}, `SELECT * FROM t WHERE a='it''s ?' AND b='x\'?' AND c='1'`},
+		{"quoted identifier", "SELECT `a?` FROM t WHERE \"b?\"=?", []interface{}{1}, "SELECT `a?` FROM t WHERE \"b?\"='1'"},
+		{"comments", "SELECT * FROM t -- a=?\nWHERE /* b=? */ c=?", []interface{}{1}, "SELECT * FROM t -- a=?\nWHERE /* b=? */ c='1'"},
+		{"null and bytes", "INSERT INTO t VALUES (?, ?)", []interface{}{nil, []byte("raw")}, "INSERT INTO t VALUES (NULL, 'raw')"},
+		{"no args", "SELECT * FROM t WHERE a=?", nil, "SELECT * FROM t WHERE a=?"},
+		{"named arg in a placeholder", "SELECT * FROM t WHERE a=? AND b=$2", []interface{}{sql.Named("a", 1), sql.Named("b", "x")}, "SELECT * FROM t WHERE a='1' AND b='x'"},
+		{"unused named arg", "SELECT * FROM t WHERE a=?", []interface{}{1, sql.Named("b", "x")}, "SELECT * FROM t WHERE a='1' /* Unused args: [b='x'] */"},
+	}
+	var f valueFormat
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.formatSQLReplace(tt.sql, tt.args); got != tt.want {
+				t.Errorf("formatSQLReplace(%q) = %q, want %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatSQLReplaceNamedArgs(t *testing.T) {
+	q := testQuery{sql: "SELECT * FROM users WHERE tenant = ? AND name = ?", args: []interface{}{sql.Named("tenant", 7), "bob"}}
+	span := runQuery(t, q, WithFormatSQLReplace(), WithRecordArgsAsJSON())
+	if v, _ := attrValue(span.Attributes, semconv.DBStatementKey); v.AsString() != "SELECT * FROM users WHERE tenant = '7' AND name = 'bob'" {
+		t.Errorf("db.statement = %q, want the named arg's value in its placeholder", v.AsString())
+	}
+	if v, _ := attrValue(span.Attributes, "db.args"); v.AsString() != `["tenant='7'","'bob'"]` {
+		t.Errorf("db.args = %s, want the named arg as name=value", v.AsString())
+	}
+}
+
+func TestTableAttributes(t *testing.T) {
+	opt := WithTableAttributes(map[string][]attribute.KeyValue{
+		"payments":  {attribute.String("sensitivity", "high")},
+		"audit.log": {attribute.Bool("audit", true)},
+	})
+	tests := []struct {
+		name        string
+		sql         string
+		sensitivity bool
+		audit       bool
+	}{
+		{"configured table", "SELECT * FROM payments WHERE id = ?", true, false},
+		{"qualified name of a configured table", "UPDATE billing.payments SET status = ?", true, false},
+		{"qualified key", "INSERT INTO audit.log (msg) VALUES (?)", false, true},
+		{"unqualified name of a qualified key", "INSERT INTO log (msg) VALUES (?)", false, false},
+		{"joined tables", "SELECT * FROM orders o JOIN payments p ON p.order_id = o.id JOIN audit.log l ON l.ref = p.id", true, true},
+		{"other table", "SELECT * FROM users", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql}, opt)
+			if v, ok := attrValue(span.Attributes, "sensitivity"); ok != tt.sensitivity || (ok && v.AsString() != "high") {
+				t.Errorf("sensitivity = %q (present %v), want present %v", v.AsString(), ok, tt.sensitivity)
+			}
+			if _, ok := attrValue(span.Attributes, "audit"); ok != tt.audit {
+				t.Errorf("audit present %v, want %v", ok, tt.audit)
+			}
+		})
+	}
+}
+
+func TestRecordExecPath(t *testing.T) {
+	failed := errors.New("connection reset")
+	tests := []struct {
+		name   string
+		sql    string
+		result sql.Result
+		err    error
+		want   bool
+	}{
+		{"select through Query", "SELECT * FROM users", nil, nil, false},
+		{"insert through Exec", "INSERT INTO users (name) VALUES (?)", rowsAffected(1), nil, true},
+		{"insert returning through Query", "INSERT INTO users (name) VALUES (?) RETURNING id", nil, nil, false},
+		{"failed insert", "INSERT INTO users (name) VALUES (?)", nil, failed, true},
+		{"failed DDL", "CREATE TABLE users (id INT)", nil, failed, true},
+		{"failed select", "SELECT * FROM users", nil, failed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql, result: tt.result, err: tt.err}, WithRecordExecPath())
+			v, ok := attrValue(span.Attributes, "db.xorm.exec")
+			if !ok || v.AsBool() != tt.want {
+				t.Errorf("db.xorm.exec = %v (present %v), want %v", v.AsBool(), ok, tt.want)
+			}
+		})
+	}
+}
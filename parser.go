@@ -0,0 +1,658 @@
+package otelxorm
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// StatementParser parses statements for the features based on ParsedSQL:
+// span names, WithStatementAttribute, WithRecordSortColumns,
+// WithRecordJoinCount and idempotency classification all share one parse
+// per query. Features that need bound arguments, such as WithRecordLimit,
+// use the built-in tokenizer.
+type StatementParser interface {
+	Parse(sql string) (ParsedSQL, error)
+}
+
+// DefaultParser is the fast heuristic parser used when no StatementParser is
+// configured. It never fails.
+var DefaultParser StatementParser = defaultParser{}
+
+type defaultParser struct{}
+
+func (defaultParser) Parse(sql string) (ParsedSQL, error) {
+	return parseSQL(sql), nil
+}
+
+// ParsedSQL is the result of parsing a statement. The default parser is best
+// effort: fields it isn't confident about are left empty.
+type ParsedSQL struct {
+	Operation StatementType
+	// Table is the main table of the statement, e.g. the table a SELECT
+	// reads from or an INSERT writes into.
+	Table string
+	// Tables lists every table referenced by the statement, in order of
+	// appearance, without duplicates.
+	Tables []string
+	// Columns lists the plain columns selected, inserted or updated.
+	// Expressions are skipped.
+	Columns []string
+	// SortColumns lists the plain columns of the outermost ORDER BY.
+	SortColumns []string
+	// JoinCount is the number of JOIN clauses of any type.
+	JoinCount int
+
+	HasWhere    bool
+	HasJoin     bool
+	HasGroupBy  bool
+	HasOrderBy  bool
+	HasLimit    bool
+	HasSubquery bool
+}
+
+// query tokenizes and parses the statement of one query at most once, so
+// that every feature needing it shares the same result.
+type query struct {
+	sql      string
+	stmtType StatementType
+	parser   StatementParser
+	tokens   []sqlToken
+	parsed   *ParsedSQL
+}
+
+func newQuery(sql string, stmtType StatementType, parser StatementParser) query {
+	return query{sql: sql, stmtType: stmtType, parser: parser}
+}
+
+func (q *query) tokenize() []sqlToken {
+	if q.tokens == nil {
+		q.tokens = tokenize(q.sql)
+	}
+	return q.tokens
+}
+
+// parse parses the statement with the configured parser, falling back to
+// the default one if it fails.
+func (q *query) parse() ParsedSQL {
+	if q.parsed != nil {
+		return *q.parsed
+	}
+	var parsed ParsedSQL
+	var err error
+	if q.parser != nil {
+		parsed, err = q.parser.Parse(q.sql)
+	}
+	if q.parser == nil || err != nil {
+		parsed = parseTokens(q.stmtType, q.tokenize())
+	}
+	if parsed.Operation == StatementUnknown {
+		parsed.Operation = q.stmtType
+	}
+	q.parsed = &parsed
+	return parsed
+}
+
+// parseSQL parses sql with the lightweight tokenizer.
+func parseSQL(sql string) ParsedSQL {
+	return parseTokens(statementTypeOf(sql), tokenize(sql))
+}
+
+func parseTokens(op StatementType, tokens []sqlToken) ParsedSQL {
+	p := ParsedSQL{Operation: op}
+	depth, tableDepth := 0, -1
+	addTable := func(name string) {
+		if tableDepth < 0 || depth < tableDepth {
+			p.Table, tableDepth = name, depth
+		}
+		for _, t := range p.Tables {
+			if t == name {
+				return
+			}
+		}
+		p.Tables = append(p.Tables, name)
+	}
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.kind == tokenPunct && t.text == "(":
+			depth++
+			if i+1 < len(tokens) && tokens[i+1].isWord("SELECT") {
+				p.HasSubquery = true
+			}
+		case t.kind == tokenPunct && t.text == ")":
+			depth--
+		case t.isWord("WHERE"):
+			p.HasWhere = true
+		case t.isWord("JOIN"), t.isWord("STRAIGHT_JOIN"):
+			p.HasJoin = true
+			p.JoinCount++
+		case t.isWord("GROUP") && i+1 < len(tokens) && tokens[i+1].isWord("BY"):
+			p.HasGroupBy = true
+		case t.isWord("ORDER") && i+1 < len(tokens) && tokens[i+1].isWord("BY"):
+			p.HasOrderBy = true
+			if depth == 0 {
+				p.SortColumns = sortColumns(tokens[i+2:])
+			}
+		case t.isWord("LIMIT"), t.isWord("FETCH"), t.isWord("TOP"):
+			p.HasLimit = true
+		}
+
+		switch {
+		case t.isWord("FROM"):
+			// FROM a, b AS x, c
+			j := i + 1
+			for {
+				name, n := qualifiedName(tokens[j:])
+				if n == 0 {
+					break
+				}
+				addTable(name)
+				j += n
+				if j < len(tokens) && tokens[j].isWord("AS") {
+					j++
+				}
+				if j < len(tokens) && tokens[j].kind != tokenPunct && !isReservedWord(tokens[j].text) {
+					j++
+				}
+				if j >= len(tokens) || tokens[j].kind != tokenPunct || tokens[j].text != "," {
+					break
+				}
+				j++
+			}
+		case t.isWord("UPDATE") && i > 0 && (tokens[i-1].isWord("FOR") || tokens[i-1].isWord("KEY")):
+			// SELECT ... FOR UPDATE, ON DUPLICATE KEY UPDATE
+		case t.isWord("JOIN"), t.isWord("INTO"), t.isWord("UPDATE"), t.isWord("TABLE"):
+			j := i + 1
+			for j < len(tokens) && (tokens[j].isWord("IF") || tokens[j].isWord("NOT") ||
+				tokens[j].isWord("EXISTS") || tokens[j].isWord("ONLY") || tokens[j].isWord("TABLE")) {
+				j++
+			}
+			name, n := qualifiedName(tokens[j:])
+			if n == 0 {
+				break
+			}
+			addTable(name)
+			if t.isWord("INTO") && j+n < len(tokens) && tokens[j+n].text == "(" && depth == 0 {
+				p.Columns = append(p.Columns, columnList(tokens[j+n+1:])...)
+			}
+		case t.isWord("SELECT") && depth == 0 && op == StatementSelect && p.Columns == nil:
+			p.Columns = selectColumns(tokens[i+1:])
+		case t.isWord("SET") && depth == 0 && op == StatementUpdate:
+			p.Columns = append(p.Columns, setColumns(tokens[i+1:])...)
+		}
+	}
+	return p
+}
+
+// qualifiedName reads a possibly dotted table name at the start of tokens
+// and returns it with the number of tokens it spans. Schema qualifiers are
+// kept, e.g. "public.users".
+func qualifiedName(tokens []sqlToken) (string, int) {
+	var parts []string
+	n := 0
+	for n < len(tokens) {
+		t := tokens[n]
+		if t.kind != tokenWord && t.kind != tokenIdent {
+			break
+		}
+		if t.kind == tokenWord && isReservedWord(t.text) {
+			break
+		}
+		parts = append(parts, t.text)
+		n++
+		if n >= len(tokens) || tokens[n].kind != tokenPunct || tokens[n].text != "." {
+			break
+		}
+		n++
+	}
+	if len(parts) == 0 {
+		return "", 0
+	}
+	return strings.Join(parts, "."), n
+}
+
+// columnList reads "a, b, c)" and returns the column names.
+func columnList(tokens []sqlToken) []string {
+	var columns []string
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.kind == tokenPunct && t.text == ")" {
+			break
+		}
+		if t.kind == tokenWord || t.kind == tokenIdent {
+			columns = append(columns, t.text)
+		}
+	}
+	return columns
+}
+
+// selectColumns returns the plain columns of a select list, up to FROM.
+// Items other than a (qualified) column name or * are skipped.
+func selectColumns(tokens []sqlToken) []string {
+	var columns []string
+	depth := 0
+	start := 0
+	item := func(end int) {
+		if end <= start {
+			return
+		}
+		items := tokens[start:end]
+		if len(items) == 1 && items[0].kind == tokenPunct && items[0].text == "*" {
+			columns = append(columns, "*")
+			return
+		}
+		if name, n := qualifiedName(items); n == len(items) {
+			columns = append(columns, name)
+		}
+	}
+	for i, t := range tokens {
+		switch {
+		case t.kind == tokenPunct && t.text == "(":
+			depth++
+		case t.kind == tokenPunct && t.text == ")":
+			depth--
+		case depth == 0 && t.kind == tokenPunct && t.text == ",":
+			item(i)
+			start = i + 1
+		case depth == 0 && t.isWord("DISTINCT") && i == start:
+			start = i + 1
+		case depth == 0 && (t.isWord("FROM") || t.isWord("WHERE") || t.isWord("LIMIT")):
+			item(i)
+			return columns
+		}
+	}
+	item(len(tokens))
+	return columns
+}
+
+// sortColumns returns the plain columns of an ORDER BY list. Items that are
+// not a (qualified) column name, optionally followed by ASC/DESC and NULLS
+// FIRST/LAST, are skipped.
+func sortColumns(tokens []sqlToken) []string {
+	var columns []string
+	for i := 0; i < len(tokens); {
+		name, n := qualifiedName(tokens[i:])
+		j := i + n
+		for j < len(tokens) && (tokens[j].isWord("ASC") || tokens[j].isWord("DESC") ||
+			tokens[j].isWord("NULLS") || tokens[j].isWord("FIRST") || tokens[j].isWord("LAST")) {
+			j++
+		}
+		end := j >= len(tokens) || tokens[j].kind == tokenPunct && (tokens[j].text == "," || tokens[j].text == ";" || tokens[j].text == ")")
+		if n > 0 && (end || isReservedWord(tokens[j].text)) {
+			columns = append(columns, name)
+		}
+		// Skip to the next item, or stop at the end of the clause.
+		depth := 0
+		for ; j < len(tokens); j++ {
+			t := tokens[j]
+			if t.kind == tokenPunct && t.text == "(" {
+				depth++
+			} else if t.kind == tokenPunct && t.text == ")" {
+				if depth == 0 {
+					return columns
+				}
+				depth--
+			} else if depth == 0 && (t.kind == tokenPunct && t.text == ";" || t.kind == tokenWord && isReservedWord(t.text)) {
+				return columns
+			} else if depth == 0 && t.kind == tokenPunct && t.text == "," {
+				break
+			}
+		}
+		i = j + 1
+	}
+	return columns
+}
+
+// setColumns returns the assigned columns of an UPDATE ... SET clause.
+func setColumns(tokens []sqlToken) []string {
+	var columns []string
+	depth := 0
+	for i, t := range tokens {
+		switch {
+		case t.kind == tokenPunct && t.text == "(":
+			depth++
+		case t.kind == tokenPunct && t.text == ")":
+			depth--
+		case depth == 0 && (t.isWord("WHERE") || t.isWord("FROM") || t.isWord("RETURNING")):
+			return columns
+		case depth == 0 && t.kind == tokenPunct && t.text == "=" && i > 0:
+			if prev := tokens[i-1]; prev.kind == tokenWord || prev.kind == tokenIdent {
+				columns = append(columns, prev.text)
+			}
+		}
+	}
+	return columns
+}
+
+var reservedWords = map[string]bool{
+	"SELECT": true, "WHERE": true, "SET": true, "VALUES": true, "VALUE": true,
+	"DEFAULT": true, "ON": true, "USING": true, "LATERAL": true, "DUAL": true,
+	"JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true,
+	"CROSS": true, "OUTER": true, "NATURAL": true, "GROUP": true, "ORDER": true,
+	"LIMIT": true, "OFFSET": true, "HAVING": true, "UNION": true, "FOR": true,
+	"AS": true, "FETCH": true, "RETURNING": true, "WINDOW": true,
+}
+
+func isReservedWord(w string) bool {
+	return reservedWords[strings.ToUpper(w)]
+}
+
+// argValue resolves the integer at tokens[i], either a literal or a
+// placeholder bound in args.
+func argValue(tokens []sqlToken, i int, args []interface{}) (int64, bool) {
+	if i < 0 || i >= len(tokens) {
+		return 0, false
+	}
+	t := tokens[i]
+	switch t.kind {
+	case tokenNumber:
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		return n, err == nil
+	case tokenPlaceholder:
+		arg, ok := placeholderArg(tokens, i, args)
+		if !ok {
+			return 0, false
+		}
+		return toInt64(arg)
+	}
+	return 0, false
+}
+
+// placeholderArg returns the argument bound to the placeholder tokens[i]:
+// $N is the Nth argument, ? the argument at its position among the ?
+// placeholders and :name or @name the sql.Named argument of that name.
+func placeholderArg(tokens []sqlToken, i int, args []interface{}) (interface{}, bool) {
+	text := tokens[i].text
+	index := -1
+	switch {
+	case text == "?":
+		index = 0
+		for _, t := range tokens[:i] {
+			if t.kind == tokenPlaceholder && t.text == "?" {
+				index++
+			}
+		}
+	case text[0] == '$':
+		n, err := strconv.Atoi(text[1:])
+		if err != nil {
+			return nil, false
+		}
+		index = n - 1
+	default:
+		for _, arg := range args {
+			if named, ok := arg.(sql.NamedArg); ok && named.Name == text[1:] {
+				return named.Value, true
+			}
+		}
+		return nil, false
+	}
+	if index < 0 || index >= len(args) {
+		return nil, false
+	}
+	if named, ok := args[index].(sql.NamedArg); ok {
+		return named.Value, true
+	}
+	return args[index], true
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint:
+		return int64(n), true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	case string:
+		i, err := strconv.ParseInt(n, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// topLevelIndexes returns the indexes of the tokens outside parentheses.
+func topLevelIndexes(tokens []sqlToken) []int {
+	indexes := make([]int, 0, len(tokens))
+	depth := 0
+	for i, t := range tokens {
+		switch {
+		case t.kind == tokenPunct && t.text == "(":
+			depth++
+		case t.kind == tokenPunct && t.text == ")":
+			depth--
+		case depth == 0:
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// limitOf returns the row limit of the outermost query: LIMIT n,
+// LIMIT offset, n, FETCH FIRST|NEXT n ROWS or TOP n. Placeholders are
+// resolved from args when possible.
+func limitOf(tokens []sqlToken, args []interface{}) (int64, bool) {
+	var limit int64
+	found := false
+	for _, i := range topLevelIndexes(tokens) {
+		t := tokens[i]
+		switch {
+		case t.isWord("LIMIT"):
+			j := i + 1
+			if j+2 < len(tokens) && tokens[j+1].kind == tokenPunct && tokens[j+1].text == "," {
+				j += 2
+			}
+			limit, found = argValue(tokens, j, args)
+		case t.isWord("FETCH") && i+1 < len(tokens) && (tokens[i+1].isWord("FIRST") || tokens[i+1].isWord("NEXT")):
+			limit, found = argValue(tokens, i+2, args)
+		case t.isWord("TOP"):
+			limit, found = argValue(tokens, i+1, args)
+		}
+	}
+	return limit, found
+}
+
+// offsetOf returns the row offset of the outermost query: OFFSET n [ROWS] or
+// LIMIT offset, n. Placeholders are resolved from args when possible.
+func offsetOf(tokens []sqlToken, args []interface{}) (int64, bool) {
+	var offset int64
+	found := false
+	for _, i := range topLevelIndexes(tokens) {
+		t := tokens[i]
+		switch {
+		case t.isWord("OFFSET"):
+			offset, found = argValue(tokens, i+1, args)
+		case t.isWord("LIMIT") && i+2 < len(tokens) && tokens[i+2].kind == tokenPunct && tokens[i+2].text == ",":
+			offset, found = argValue(tokens, i+1, args)
+		}
+	}
+	return offset, found
+}
+
+// indexUnfriendly reports whether the statement has a predicate that
+// usually prevents index use: a LIKE pattern starting with a wildcard, or a
+// function call compared in a WHERE clause, e.g. LOWER(email) = ?. It is a
+// heuristic and may miss cases or flag harmless ones.
+func indexUnfriendly(tokens []sqlToken, args []interface{}) bool {
+	inWhere := false
+	for i, t := range tokens {
+		switch {
+		case t.isWord("WHERE"):
+			inWhere = true
+		case t.isWord("GROUP"), t.isWord("ORDER"), t.isWord("LIMIT"), t.isWord("HAVING"):
+			inWhere = false
+		case t.isWord("LIKE") || t.isWord("ILIKE"):
+			if i+1 < len(tokens) && leadingWildcard(tokens, i+1, args) {
+				return true
+			}
+		case inWhere && t.kind == tokenWord && !isReservedWord(t.text) && !conditionWords[strings.ToUpper(t.text)] &&
+			i+1 < len(tokens) && tokens[i+1].kind == tokenPunct && tokens[i+1].text == "(":
+			if end := closingParen(tokens, i+1); end+1 < len(tokens) && isComparison(tokens[end+1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// conditionWords are keywords that may precede a parenthesis in a WHERE
+// clause without being a function call.
+var conditionWords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "IN": true, "EXISTS": true,
+	"ANY": true, "ALL": true, "SOME": true, "BETWEEN": true, "LIKE": true,
+}
+
+func leadingWildcard(tokens []sqlToken, i int, args []interface{}) bool {
+	t := tokens[i]
+	switch t.kind {
+	case tokenString:
+		return strings.HasPrefix(strings.Trim(t.text, "'"), "%") || strings.HasPrefix(strings.Trim(t.text, "'"), "_")
+	case tokenPlaceholder:
+		arg, ok := placeholderArg(tokens, i, args)
+		if !ok {
+			return false
+		}
+		s, ok := arg.(string)
+		return ok && (strings.HasPrefix(s, "%") || strings.HasPrefix(s, "_"))
+	}
+	return false
+}
+
+// closingParen returns the index of the parenthesis closing tokens[open].
+func closingParen(tokens []sqlToken, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		switch {
+		case tokens[i].kind == tokenPunct && tokens[i].text == "(":
+			depth++
+		case tokens[i].kind == tokenPunct && tokens[i].text == ")":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(tokens)
+}
+
+func isComparison(t sqlToken) bool {
+	if t.kind == tokenPunct {
+		return t.text == "=" || t.text == "<" || t.text == ">" || t.text == "!"
+	}
+	return t.isWord("LIKE") || t.isWord("ILIKE") || t.isWord("IN") || t.isWord("BETWEEN") || t.isWord("IS")
+}
+
+// parameterized reports whether the statement passes its values as bound
+// parameters. When there are arguments, it does if it has placeholders to
+// bind them to. Without arguments, it does if it inlines no value: string
+// and numeric literals count, except the row counts of LIMIT, OFFSET,
+// FETCH and TOP, which are rarely bound.
+func parameterized(tokens []sqlToken, args []interface{}) bool {
+	if len(args) > 0 {
+		for _, t := range tokens {
+			if t.kind == tokenPlaceholder {
+				return true
+			}
+		}
+		return false
+	}
+	for i, t := range tokens {
+		switch {
+		case t.kind == tokenString:
+			return false
+		case t.kind == tokenNumber && !isRowCount(tokens, i):
+			return false
+		}
+	}
+	return true
+}
+
+// isRowCount reports whether the number tokens[i] is the operand of a LIMIT,
+// OFFSET, FETCH FIRST|NEXT or TOP clause, including both operands of
+// LIMIT offset, n.
+func isRowCount(tokens []sqlToken, i int) bool {
+	if i >= 3 && tokens[i-1].kind == tokenPunct && tokens[i-1].text == "," && tokens[i-2].kind == tokenNumber {
+		i -= 2
+	}
+	if i == 0 {
+		return false
+	}
+	prev := tokens[i-1]
+	return prev.isWord("LIMIT") || prev.isWord("OFFSET") || prev.isWord("TOP") || prev.isWord("FIRST") || prev.isWord("NEXT")
+}
+
+// paginationStyle classifies how the statement pages through rows: "cursor"
+// for keyset pagination (a range predicate on an ORDER BY column with a
+// limit), "offset" for a limit with or without an offset, "none" otherwise.
+func paginationStyle(tokens []sqlToken, parsed ParsedSQL) string {
+	hasLimit, hasOffset := false, false
+	for _, i := range topLevelIndexes(tokens) {
+		t := tokens[i]
+		switch {
+		case t.isWord("LIMIT"), t.isWord("TOP"):
+			hasLimit = true
+			if i+2 < len(tokens) && tokens[i+2].kind == tokenPunct && tokens[i+2].text == "," {
+				hasOffset = true
+			}
+		case t.isWord("FETCH"):
+			hasLimit = true
+		case t.isWord("OFFSET"):
+			hasOffset = true
+		}
+	}
+	switch {
+	case hasOffset:
+		return "offset"
+	case hasLimit && rangeOnSortColumn(tokens, parsed.SortColumns):
+		return "cursor"
+	case hasLimit:
+		return "offset"
+	}
+	return "none"
+}
+
+// rangeOnSortColumn reports whether the WHERE clause compares one of
+// columns with <, <=, > or >=.
+func rangeOnSortColumn(tokens []sqlToken, columns []string) bool {
+	if len(columns) == 0 {
+		return false
+	}
+	inWhere := false
+	for i, t := range tokens {
+		switch {
+		case t.isWord("WHERE"):
+			inWhere = true
+		case t.isWord("GROUP"), t.isWord("ORDER"), t.isWord("LIMIT"):
+			inWhere = false
+		case inWhere && (t.kind == tokenWord || t.kind == tokenIdent) && i+1 < len(tokens) &&
+			tokens[i+1].kind == tokenPunct && (tokens[i+1].text == "<" || tokens[i+1].text == ">"):
+			for _, column := range columns {
+				if strings.EqualFold(lastSegment(column), t.text) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func lastSegment(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
@@ -0,0 +1,437 @@
+package otelxorm
+
+import (
+	"database/sql"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.18.0"
+	"reflect"
+	"strings"
+	"testing"
+	"xorm.io/xorm/contexts"
+)
+
+// countingParser counts the statements it parses.
+type countingParser struct {
+	calls int
+}
+
+func (p *countingParser) Parse(sql string) (ParsedSQL, error) {
+	p.calls++
+	return parseSQL(sql), nil
+}
+
+func TestStatementAttribute(t *testing.T) {
+	opts := []Option{
+		WithStatementAttribute("app.table", func(parsed ParsedSQL) string { return parsed.Table }),
+		WithStatementAttribute("app.operation", func(parsed ParsedSQL) string { return string(parsed.Operation) }),
+		WithStatementAttribute("app.tables", func(parsed ParsedSQL) string { return strings.Join(parsed.Tables, ",") }),
+	}
+	tests := []struct {
+		sql  string
+		want map[string]string
+	}{
+		{"SELECT * FROM users u JOIN orders o ON o.user_id = u.id", map[string]string{"app.table": "users", "app.operation": "SELECT", "app.tables": "users,orders"}},
+		{"UPDATE accounts SET balance = ?", map[string]string{"app.table": "accounts", "app.operation": "UPDATE", "app.tables": "accounts"}},
+		{"SELECT 1", map[string]string{"app.operation": "SELECT"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql}, opts...)
+			for _, key := range []string{"app.table", "app.operation", "app.tables"} {
+				v, ok := attrValue(span.Attributes, attribute.Key(key))
+				want, wantOK := tt.want[key]
+				if ok != wantOK || v.AsString() != want {
+					t.Errorf("%s = %q (present %v), want %q (present %v)", key, v.AsString(), ok, want, wantOK)
+				}
+			}
+		})
+	}
+}
+
+func TestStatementParsedOncePerQuery(t *testing.T) {
+	parser := &countingParser{}
+	h, _ := newTestHook(
+		WithStatementParser(parser),
+		WithStatementAttribute("app.table", func(parsed ParsedSQL) string { return parsed.Table }),
+		WithStatementAttribute("app.operation", func(parsed ParsedSQL) string { return string(parsed.Operation) }),
+		WithRecordSortColumns(),
+		WithRecordJoinCount(),
+	)
+	testQuery{sql: "SELECT * FROM users ORDER BY name"}.run(t, h)
+	if parser.calls != 1 {
+		t.Errorf("statement parsed %d times, want 1", parser.calls)
+	}
+}
+
+func TestRecordLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		sql    string
+		args   []interface{}
+		want   int64
+		wantOK bool
+	}{
+		{"literal", "SELECT * FROM users LIMIT 10", nil, 10, true},
+		{"offset then limit", "SELECT * FROM users LIMIT 20, 10", nil, 10, true},
+		{"question mark", "SELECT * FROM users WHERE age > ? LIMIT ?", []interface{}{18, 25}, 25, true},
+		{"dollar", "SELECT * FROM users WHERE age > $1 LIMIT $2 OFFSET $3", []interface{}{18, int64(50), 100}, 50, true},
+		{"named", "SELECT * FROM users LIMIT @n", []interface{}{sql.Named("n", 5)}, 5, true},
+		{"string arg", "SELECT * FROM users LIMIT ?", []interface{}{"15"}, 15, true},
+		{"fetch first", "SELECT * FROM users ORDER BY id FETCH FIRST 3 ROWS ONLY", nil, 3, true},
+		{"top", "SELECT TOP 7 * FROM users", nil, 7, true},
+		{"subquery limit ignored", "SELECT * FROM (SELECT * FROM users LIMIT 5) u", nil, 0, false},
+		{"unresolved placeholder", "SELECT * FROM users LIMIT ?", nil, 0, false},
+		{"non-integer arg", "SELECT * FROM users LIMIT ?", []interface{}{1.5}, 0, false},
+		{"no limit", "SELECT * FROM users", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql, args: tt.args}, WithRecordLimit())
+			v, ok := attrValue(span.Attributes, "db.limit")
+			if ok != tt.wantOK || v.AsInt64() != tt.want {
+				t.Errorf("db.limit = %d (present %v), want %d (present %v)", v.AsInt64(), ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestFlagDeepPagination(t *testing.T) {
+	tests := []struct {
+		name       string
+		sql        string
+		args       []interface{}
+		wantOffset int64
+		wantOK     bool
+		wantDeep   bool
+	}{
+		{"literal under", "SELECT * FROM users LIMIT 10 OFFSET 999", nil, 999, true, false},
+		{"literal at threshold", "SELECT * FROM users LIMIT 10 OFFSET 1000", nil, 1000, true, false},
+		{"literal over", "SELECT * FROM users LIMIT 10 OFFSET 1001", nil, 1001, true, true},
+		{"limit offset, n", "SELECT * FROM users LIMIT 5000, 10", nil, 5000, true, true},
+		{"parameterized under", "SELECT * FROM users LIMIT ? OFFSET ?", []interface{}{10, 20}, 20, true, false},
+		{"parameterized over", "SELECT * FROM users LIMIT $1 OFFSET $2", []interface{}{10, 2000}, 2000, true, true},
+		{"offset rows", "SELECT * FROM users ORDER BY id OFFSET 1500 ROWS FETCH NEXT 10 ROWS ONLY", nil, 1500, true, true},
+		{"unresolved", "SELECT * FROM users LIMIT 10 OFFSET ?", nil, 0, false, false},
+		{"no offset", "SELECT * FROM users LIMIT 10", nil, 0, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql, args: tt.args}, WithFlagDeepPagination(1000))
+			offset, ok := attrValue(span.Attributes, "db.offset")
+			if ok != tt.wantOK || offset.AsInt64() != tt.wantOffset {
+				t.Errorf("db.offset = %d (present %v), want %d (present %v)", offset.AsInt64(), ok, tt.wantOffset, tt.wantOK)
+			}
+			if _, deep := attrValue(span.Attributes, "db.deep_pagination"); deep != tt.wantDeep {
+				t.Errorf("db.deep_pagination present %v, want %v", deep, tt.wantDeep)
+			}
+		})
+	}
+}
+
+func TestRecordOffsetWithoutThreshold(t *testing.T) {
+	span := runQuery(t, testQuery{sql: "SELECT * FROM users LIMIT 10 OFFSET 100000"}, WithRecordOffset())
+	if v, _ := attrValue(span.Attributes, "db.offset"); v.AsInt64() != 100000 {
+		t.Errorf("db.offset = %d, want 100000", v.AsInt64())
+	}
+	if _, ok := attrValue(span.Attributes, "db.deep_pagination"); ok {
+		t.Error("db.deep_pagination set without a threshold")
+	}
+}
+
+func TestRecordSortColumns(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"single", "SELECT * FROM users ORDER BY name", []string{"name"}},
+		{"direction", "SELECT * FROM users ORDER BY created_at DESC", []string{"created_at"}},
+		{"multiple", "SELECT * FROM users ORDER BY last_name ASC, first_name, id DESC LIMIT 10", []string{"last_name", "first_name", "id"}},
+		{"qualified and quoted", "SELECT * FROM users u ORDER BY u.`name`, \"age\" NULLS LAST", []string{"u.name", "age"}},
+		{"expression skipped", "SELECT * FROM users ORDER BY LOWER(name), id", []string{"id"}},
+		{"subquery order ignored", "SELECT * FROM (SELECT * FROM users ORDER BY age) u ORDER BY name", []string{"name"}},
+		{"no order by", "SELECT * FROM users", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql}, WithRecordSortColumns())
+			v, ok := attrValue(span.Attributes, "db.sort.columns")
+			if ok != (tt.want != nil) || !reflect.DeepEqual(v.AsStringSlice(), tt.want) {
+				t.Errorf("db.sort.columns = %q (present %v), want %q", v.AsStringSlice(), ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlagIndexUnfriendly(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		args []interface{}
+		want bool
+	}{
+		{"leading wildcard literal", "SELECT * FROM users WHERE name LIKE '%son'", nil, true},
+		{"leading wildcard arg", "SELECT * FROM users WHERE name LIKE ?", []interface{}{"%son"}, true},
+		{"single character wildcard", "SELECT * FROM users WHERE code LIKE '_42'", nil, true},
+		{"trailing wildcard", "SELECT * FROM users WHERE name LIKE 'john%'", nil, false},
+		{"trailing wildcard arg", "SELECT * FROM users WHERE name LIKE ?", []interface{}{"john%"}, false},
+		{"function-wrapped column", "SELECT * FROM users WHERE LOWER(email) = ?", nil, true},
+		{"function-wrapped column after AND", "SELECT * FROM users WHERE active = 1 AND DATE(created_at) > ?", nil, true},
+		{"function on the value side", "SELECT * FROM users WHERE email = LOWER(?)", nil, false},
+		{"IN list", "SELECT * FROM users WHERE id IN (?, ?)", nil, false},
+		{"function in the select list", "SELECT LOWER(email) FROM users WHERE id = ?", nil, false},
+		{"plain predicate", "SELECT * FROM users WHERE email = ?", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql, args: tt.args}, WithFlagIndexUnfriendly())
+			if _, ok := attrValue(span.Attributes, "db.index_unfriendly"); ok != tt.want {
+				t.Errorf("db.index_unfriendly present %v, want %v", ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordJoinCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		sql    string
+		want   int64
+		wantOK bool
+	}{
+		{"no join", "SELECT * FROM users", 0, true},
+		{"inner", "SELECT * FROM a INNER JOIN b ON b.a_id = a.id", 1, true},
+		{"all types", "SELECT * FROM a JOIN b ON b.a = a.id LEFT JOIN c ON c.b = b.id RIGHT OUTER JOIN d ON d.c = c.id FULL JOIN e ON e.d = d.id CROSS JOIN f", 5, true},
+		{"subquery joins counted", "SELECT * FROM a WHERE id IN (SELECT a_id FROM b JOIN c ON c.b = b.id)", 1, true},
+		{"literal containing join", "SELECT * FROM users WHERE note = 'left join here' OR tag = 'JOIN'", 0, true},
+		{"identifier containing join", "SELECT joined_at FROM users", 0, true},
+		{"quoted identifier", "SELECT \"join\" FROM users", 0, true},
+		{"not a select", "UPDATE users SET name = ?", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql}, WithRecordJoinCount())
+			v, ok := attrValue(span.Attributes, "db.join.count")
+			if ok != tt.wantOK || v.AsInt64() != tt.want {
+				t.Errorf("db.join.count = %d (present %v), want %d (present %v)", v.AsInt64(), ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRecordIdempotency(t *testing.T) {
+	keyedUpdate := func(c *contexts.ContextHook, parsed ParsedSQL) bool {
+		if parsed.Operation == StatementUpdate {
+			return parsed.HasWhere && strings.Contains(c.SQL, "WHERE id = ?")
+		}
+		return parsed.Operation == StatementSelect
+	}
+	tests := []struct {
+		name string
+		opt  Option
+		sql  string
+		want bool
+	}{
+		{"select", WithRecordIdempotency(), "SELECT * FROM users", true},
+		{"unconditional update", WithRecordIdempotency(), "UPDATE users SET active = ?", false},
+		{"keyed update", WithRecordIdempotency(), "UPDATE users SET active = ? WHERE id = ?", false},
+		{"insert", WithRecordIdempotency(), "INSERT INTO users (name) VALUES (?)", false},
+		{"classifier select", WithIdempotencyClassifier(keyedUpdate), "SELECT * FROM users", true},
+		{"classifier unconditional update", WithIdempotencyClassifier(keyedUpdate), "UPDATE users SET active = ?", false},
+		{"classifier keyed update", WithIdempotencyClassifier(keyedUpdate), "UPDATE users SET active = ? WHERE id = ?", true},
+		{"classifier insert", WithIdempotencyClassifier(keyedUpdate), "INSERT INTO users (name) VALUES (?)", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql}, tt.opt)
+			v, ok := attrValue(span.Attributes, "db.idempotent")
+			if !ok || v.AsBool() != tt.want {
+				t.Errorf("db.idempotent = %v (present %v), want %v", v.AsBool(), ok, tt.want)
+			}
+		})
+	}
+
+	span := runQuery(t, testQuery{sql: "SELECT * FROM users"})
+	if _, ok := attrValue(span.Attributes, "db.idempotent"); ok {
+		t.Error("db.idempotent recorded without WithRecordIdempotency")
+	}
+}
+
+func TestFlagUnparameterized(t *testing.T) {
+	tests := []struct {
+		name   string
+		sql    string
+		args   []interface{}
+		want   bool
+		wantOK bool
+	}{
+		{"placeholders with args", "SELECT * FROM users WHERE name = ?", []interface{}{"bob"}, true, true},
+		{"dollar placeholders with args", "UPDATE users SET name = $1 WHERE id = $2", []interface{}{"bob", 1}, true, true},
+		{"named placeholders with args", "SELECT * FROM users WHERE id = @id", []interface{}{sql.Named("id", 1)}, true, true},
+		{"args without placeholders", "SELECT * FROM users WHERE name = 'bob'", []interface{}{"bob"}, false, true},
+		{"concatenated string", "SELECT * FROM users WHERE name = 'bob'", nil, false, true},
+		{"concatenated number", "SELECT * FROM users WHERE id = 42", nil, false, true},
+		{"concatenated insert", "INSERT INTO users (name, age) VALUES ('bob', 42)", nil, false, true},
+		{"cast with a literal", "SELECT x::text FROM t WHERE name = 'bob'", nil, false, true},
+		{"cast with a placeholder", "SELECT * FROM t WHERE created_at > $1::date", []interface{}{"2024-01-01"}, true, true},
+		{"no values", "SELECT * FROM users", nil, true, true},
+		{"limit and offset", "SELECT * FROM users ORDER BY id LIMIT 10 OFFSET 20", nil, true, true},
+		{"limit offset, n", "SELECT * FROM users LIMIT 20, 10", nil, true, true},
+		{"fetch first", "SELECT * FROM users ORDER BY id FETCH FIRST 5 ROWS ONLY", nil, true, true},
+		{"not a data statement", "CREATE TABLE users (id INT DEFAULT 0)", nil, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql, args: tt.args}, WithFlagUnparameterized())
+			v, ok := attrValue(span.Attributes, "db.parameterized")
+			if ok != tt.wantOK || v.AsBool() != tt.want {
+				t.Errorf("db.parameterized = %v (present %v), want %v (present %v)", v.AsBool(), ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// fakeParser returns a fixed result, or an error if err is set.
+type fakeParser struct {
+	parsed ParsedSQL
+	err    error
+}
+
+func (p fakeParser) Parse(string) (ParsedSQL, error) {
+	return p.parsed, p.err
+}
+
+func TestStatementParser(t *testing.T) {
+	const stmt = "SELECT * FROM users u JOIN orders o ON o.user_id = u.id ORDER BY u.name"
+	fake := ParsedSQL{
+		Operation:   StatementSelect,
+		Table:       "accounts",
+		Tables:      []string{"accounts"},
+		SortColumns: []string{"created_at"},
+		JoinCount:   4,
+		HasJoin:     true,
+	}
+	tests := []struct {
+		name        string
+		parser      StatementParser
+		wantName    string
+		wantTable   string
+		wantSort    []string
+		wantJoins   int64
+		wantApplied string
+	}{
+		{"default", nil, "SELECT users", "users", []string{"u.name"}, 1, "users"},
+		{"explicit default", DefaultParser, "SELECT users", "users", []string{"u.name"}, 1, "users"},
+		{"fake", fakeParser{parsed: fake}, "SELECT accounts", "accounts", []string{"created_at"}, 4, "accounts"},
+		{"failing falls back to the default", fakeParser{parsed: fake, err: errors.New("syntax error")}, "SELECT users", "users", []string{"u.name"}, 1, "users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{
+				WithLazySpanNaming(),
+				WithStructuredAttributes(),
+				WithRecordSortColumns(),
+				WithRecordJoinCount(),
+				WithStatementAttribute("app.table", func(parsed ParsedSQL) string { return parsed.Table }),
+			}
+			if tt.parser != nil {
+				opts = append(opts, WithStatementParser(tt.parser))
+			}
+			span := runQuery(t, testQuery{sql: stmt}, opts...)
+			if span.Name != tt.wantName {
+				t.Errorf("span name = %q, want %q", span.Name, tt.wantName)
+			}
+			if v, _ := attrValue(span.Attributes, semconv.DBSQLTableKey); v.AsString() != tt.wantTable {
+				t.Errorf("db.sql.table = %q, want %q", v.AsString(), tt.wantTable)
+			}
+			if v, _ := attrValue(span.Attributes, "db.sort.columns"); !reflect.DeepEqual(v.AsStringSlice(), tt.wantSort) {
+				t.Errorf("db.sort.columns = %q, want %q", v.AsStringSlice(), tt.wantSort)
+			}
+			if v, _ := attrValue(span.Attributes, "db.join.count"); v.AsInt64() != tt.wantJoins {
+				t.Errorf("db.join.count = %d, want %d", v.AsInt64(), tt.wantJoins)
+			}
+			if v, _ := attrValue(span.Attributes, "app.table"); v.AsString() != tt.wantApplied {
+				t.Errorf("app.table = %q, want %q", v.AsString(), tt.wantApplied)
+			}
+		})
+	}
+}
+
+func TestStructuredAttributes(t *testing.T) {
+	tests := []struct {
+		name      string
+		sql       string
+		disabled  bool
+		wantOp    string
+		wantTable string
+	}{
+		{"select", "SELECT * FROM users WHERE id = ?", false, "SELECT", "users"},
+		{"insert", "INSERT INTO orders (id) VALUES (?)", false, "INSERT", "orders"},
+		{"update", "UPDATE `accounts` SET balance = ?", false, "UPDATE", "accounts"},
+		{"delete", "DELETE FROM sessions WHERE expires_at < ?", false, "DELETE", "sessions"},
+		{"ddl", "CREATE TABLE users (id INT)", false, "CREATE", "users"},
+		{"select without table", "SELECT 1", false, "SELECT", ""},
+		{"begin", "BEGIN", false, "BEGIN", ""},
+		{"pragma", "PRAGMA foreign_keys = ON", false, "PRAGMA", ""},
+		{"unknown", "(SELECT 1)", false, "", ""},
+		{"disabled", "SELECT * FROM users", true, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []Option
+			if !tt.disabled {
+				opts = append(opts, WithStructuredAttributes())
+			}
+			span := runQuery(t, testQuery{sql: tt.sql}, opts...)
+			op, ok := attrValue(span.Attributes, semconv.DBOperationKey)
+			if ok != (tt.wantOp != "") || op.AsString() != tt.wantOp {
+				t.Errorf("db.operation = %q (present %v), want %q", op.AsString(), ok, tt.wantOp)
+			}
+			table, ok := attrValue(span.Attributes, semconv.DBSQLTableKey)
+			if ok != (tt.wantTable != "") || table.AsString() != tt.wantTable {
+				t.Errorf("db.sql.table = %q (present %v), want %q", table.AsString(), ok, tt.wantTable)
+			}
+		})
+	}
+}
+
+func TestStatementParserOperationFallback(t *testing.T) {
+	span := runQuery(t, testQuery{sql: "DELETE FROM sessions"}, WithLazySpanNaming(), WithStatementParser(fakeParser{parsed: ParsedSQL{Table: "sessions"}}))
+	if span.Name != "DELETE sessions" {
+		t.Errorf("span name = %q, want the operation classified by the hook", span.Name)
+	}
+}
+
+func TestRecordPaginationStyle(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{"cursor", "SELECT * FROM users WHERE id > ? ORDER BY id LIMIT ?", "cursor"},
+		{"cursor descending", "SELECT * FROM posts WHERE p.created_at < ? ORDER BY p.created_at DESC LIMIT 20", "cursor"},
+		{"cursor with >=", "SELECT * FROM users WHERE id >= ? ORDER BY id LIMIT 10", "cursor"},
+		{"offset", "SELECT * FROM users ORDER BY id LIMIT 10 OFFSET 20", "offset"},
+		{"limit offset, n", "SELECT * FROM users ORDER BY id LIMIT 20, 10", "offset"},
+		{"limit only", "SELECT * FROM users ORDER BY id LIMIT 10", "offset"},
+		{"range on another column", "SELECT * FROM users WHERE age > ? ORDER BY id LIMIT 10", "offset"},
+		{"offset wins over a range", "SELECT * FROM users WHERE id > ? ORDER BY id LIMIT 10 OFFSET 10", "offset"},
+		{"fetch first", "SELECT * FROM users WHERE id > ? ORDER BY id FETCH FIRST 10 ROWS ONLY", "cursor"},
+		{"unpaginated", "SELECT * FROM users WHERE id > ? ORDER BY id", "none"},
+		{"subquery limit ignored", "SELECT * FROM (SELECT * FROM users LIMIT 5) u", "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql}, WithRecordPaginationStyle())
+			if v, _ := attrValue(span.Attributes, "db.pagination.style"); v.AsString() != tt.want {
+				t.Errorf("db.pagination.style = %q, want %q", v.AsString(), tt.want)
+			}
+		})
+	}
+
+	span := runQuery(t, testQuery{sql: "UPDATE users SET name = ? LIMIT 1"}, WithRecordPaginationStyle())
+	if _, ok := attrValue(span.Attributes, "db.pagination.style"); ok {
+		t.Error("db.pagination.style recorded for an UPDATE")
+	}
+}
@@ -0,0 +1,71 @@
+package otelxorm
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+// ConfigSnapshot is a read-only view of the effective configuration of an
+// OpenTelemetryHook.
+type ConfigSnapshot struct {
+	DBName     string
+	SpanName   string
+	Attributes []attribute.KeyValue
+	// RecordStatement reports whether db.statement is recorded.
+	RecordStatement bool
+	// ParameterizedStatement reports whether db.statement holds the SQL
+	// without its arguments (WithRedactArgs, or WithRecordArgValue alone).
+	ParameterizedStatement bool
+	// Formatter is "default", "replace" (WithFormatSQLReplace), "custom"
+	// (WithFormatSQL) or "none" when db.statement is not formatted.
+	Formatter string
+	// FormatterByOperation maps the statement types formatted by
+	// WithFormatSQLByOperation to "custom"; nil when db.statement is not
+	// formatted.
+	FormatterByOperation  map[StatementType]string
+	TagMigrations         bool
+	MigrationSpanName     string
+	MassMutationThreshold int64
+	MaxAttributeLength    int
+	SlowThreshold         time.Duration
+	// DeepPaginationThreshold is the offset above which queries are flagged.
+	DeepPaginationThreshold int
+	// SlowThresholdByOperation is a copy of the per-operation thresholds.
+	SlowThresholdByOperation map[StatementType]time.Duration
+}
+
+// Config returns a snapshot of the hook's effective configuration. Changing
+// the snapshot does not affect the hook.
+func (h *OpenTelemetryHook) Config() ConfigSnapshot {
+	cfg := h.config
+	snapshot := ConfigSnapshot{
+		DBName:                  cfg.dbName,
+		SpanName:                cfg.spanName,
+		Attributes:              append([]attribute.KeyValue(nil), cfg.attrs...),
+		RecordStatement:         !cfg.disableStatement,
+		ParameterizedStatement:  !cfg.disableStatement && !cfg.formatsStatement(),
+		Formatter:               "none",
+		TagMigrations:           cfg.tagMigrations,
+		MigrationSpanName:       cfg.migrationSpanName,
+		MassMutationThreshold:   cfg.massMutationThreshold,
+		MaxAttributeLength:      cfg.maxAttributeLength,
+		SlowThreshold:           cfg.slowThreshold,
+		DeepPaginationThreshold: cfg.deepPaginationThreshold,
+	}
+	if cfg.formatsStatement() {
+		snapshot.Formatter = cfg.formatSQLName
+		if len(cfg.formatSQLByOperation) > 0 {
+			snapshot.FormatterByOperation = make(map[StatementType]string, len(cfg.formatSQLByOperation))
+			for t := range cfg.formatSQLByOperation {
+				snapshot.FormatterByOperation[t] = "custom"
+			}
+		}
+	}
+	if len(cfg.slowThresholdByOperation) > 0 {
+		snapshot.SlowThresholdByOperation = make(map[StatementType]time.Duration, len(cfg.slowThresholdByOperation))
+		for t, d := range cfg.slowThresholdByOperation {
+			snapshot.SlowThresholdByOperation[t] = d
+		}
+	}
+	return snapshot
+}
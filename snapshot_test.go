@@ -0,0 +1,115 @@
+package otelxorm
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want ConfigSnapshot
+	}{
+		{
+			name: "defaults",
+			want: ConfigSnapshot{RecordStatement: true, Formatter: "default"},
+		},
+		{
+			name: "configured",
+			opts: []Option{
+				WithDBName("app"),
+				WithSpanName("xorm.query"),
+				WithAttributes(attribute.String("team", "billing")),
+				WithDisableDBStatement(),
+				WithFormatSQLReplace(),
+				WithTagMigrations(),
+				WithMigrationSpanName("migration"),
+				WithMassMutationThreshold(100),
+				WithMaxAttributeLength(512),
+				WithSlowThreshold(time.Second),
+				WithSlowThresholdByOperation(map[StatementType]time.Duration{StatementSelect: 2 * time.Second}),
+				WithFlagDeepPagination(1000),
+			},
+			want: ConfigSnapshot{
+				DBName:                   "app",
+				SpanName:                 "xorm.query",
+				Attributes:               []attribute.KeyValue{attribute.String("db.name", "app"), attribute.String("team", "billing")},
+				RecordStatement:          false,
+				Formatter:                "none",
+				TagMigrations:            true,
+				MigrationSpanName:        "migration",
+				MassMutationThreshold:    100,
+				MaxAttributeLength:       512,
+				SlowThreshold:            time.Second,
+				DeepPaginationThreshold:  1000,
+				SlowThresholdByOperation: map[StatementType]time.Duration{StatementSelect: 2 * time.Second},
+			},
+		},
+		{
+			name: "custom formatter",
+			opts: []Option{WithFormatSQL(func(sql string, args []interface{}) string { return "/* custom */ " + sql })},
+			want: ConfigSnapshot{RecordStatement: true, Formatter: "custom"},
+		},
+		{
+			name: "formatter by operation",
+			opts: []Option{WithFormatSQLReplace(), WithFormatSQLByOperation(map[StatementType]func(string, []interface{}) string{
+				StatementInsert: func(sql string, args []interface{}) string { return sql },
+			})},
+			want: ConfigSnapshot{RecordStatement: true, Formatter: "replace", FormatterByOperation: map[StatementType]string{StatementInsert: "custom"}},
+		},
+		{
+			name: "redacted args",
+			opts: []Option{WithFormatSQLReplace(), WithRedactArgs()},
+			want: ConfigSnapshot{RecordStatement: true, ParameterizedStatement: true, Formatter: "none"},
+		},
+		{
+			name: "selected args only",
+			opts: []Option{WithFormatSQLReplace(), WithRecordArgValue("tenant")},
+			want: ConfigSnapshot{RecordStatement: true, ParameterizedStatement: true, Formatter: "none"},
+		},
+		{
+			name: "selected args and all args",
+			opts: []Option{WithFormatSQLReplace(), WithRecordArgValue("tenant"), WithRecordArgs()},
+			want: ConfigSnapshot{RecordStatement: true, Formatter: "replace"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, exp := newTestHook(tt.opts...)
+			if got := h.Config(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Config() = %+v, want %+v", got, tt.want)
+			}
+			// The snapshot agrees with what AfterProcess records.
+			const query = "SELECT * FROM users WHERE tenant = ?"
+			testQuery{sql: query, args: []interface{}{"7"}}.run(t, h)
+			statement, ok := attrValue(exp.GetSpans()[0].Attributes, "db.statement")
+			if ok != tt.want.RecordStatement {
+				t.Errorf("db.statement present %v, want %v", ok, tt.want.RecordStatement)
+			}
+			if parameterized := statement.AsString() == query; ok && parameterized != tt.want.ParameterizedStatement {
+				t.Errorf("db.statement = %q, want parameterized %v", statement.AsString(), tt.want.ParameterizedStatement)
+			}
+		})
+	}
+}
+
+func TestConfigIsACopy(t *testing.T) {
+	h, _ := newTestHook(
+		WithAttributes(attribute.String("team", "billing")),
+		WithSlowThresholdByOperation(map[StatementType]time.Duration{StatementSelect: time.Second}),
+	)
+	snapshot := h.Config()
+	snapshot.Attributes[0] = attribute.String("team", "changed")
+	snapshot.SlowThresholdByOperation[StatementSelect] = time.Minute
+
+	again := h.Config()
+	if again.Attributes[0].Value.AsString() != "billing" {
+		t.Error("changing the snapshot's attributes changed the hook")
+	}
+	if again.SlowThresholdByOperation[StatementSelect] != time.Second {
+		t.Error("changing the snapshot's thresholds changed the hook")
+	}
+}
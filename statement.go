@@ -0,0 +1,268 @@
+package otelxorm
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// StatementType is the leading SQL verb of a statement, upper-cased.
+type StatementType string
+
+const (
+	StatementUnknown  StatementType = ""
+	StatementSelect   StatementType = "SELECT"
+	StatementInsert   StatementType = "INSERT"
+	StatementUpdate   StatementType = "UPDATE"
+	StatementDelete   StatementType = "DELETE"
+	StatementReplace  StatementType = "REPLACE"
+	StatementCreate   StatementType = "CREATE"
+	StatementAlter    StatementType = "ALTER"
+	StatementDrop     StatementType = "DROP"
+	StatementTruncate StatementType = "TRUNCATE"
+	StatementRename   StatementType = "RENAME"
+)
+
+// IsDDL reports whether the statement changes the schema.
+func (t StatementType) IsDDL() bool {
+	switch t {
+	case StatementCreate, StatementAlter, StatementDrop, StatementTruncate, StatementRename:
+		return true
+	}
+	return false
+}
+
+// IsWrite reports whether the statement modifies rows.
+func (t StatementType) IsWrite() bool {
+	switch t {
+	case StatementInsert, StatementUpdate, StatementDelete, StatementReplace:
+		return true
+	}
+	return false
+}
+
+// statementTypeOf returns the leading verb of sql, skipping whitespace and
+// comments, or the verb of the main statement after a WITH clause. Verbs
+// that are not in the StatementType list are still returned upper-cased,
+// e.g. "BEGIN" or "PRAGMA".
+func statementTypeOf(sql string) StatementType {
+	sql = skipSpaceAndComments(sql)
+	end := strings.IndexFunc(sql, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if end < 0 {
+		end = len(sql)
+	}
+	verb := StatementType(strings.ToUpper(sql[:end]))
+	if verb == "WITH" {
+		return cteStatementType(tokenize(sql))
+	}
+	return verb
+}
+
+// cteStatementType returns the verb following the common table expressions
+// of a WITH statement.
+func cteStatementType(tokens []sqlToken) StatementType {
+	for _, i := range topLevelIndexes(tokens) {
+		switch t := tokens[i]; {
+		case t.isWord("SELECT"), t.isWord("INSERT"), t.isWord("UPDATE"), t.isWord("DELETE"), t.isWord("REPLACE"), t.isWord("MERGE"):
+			return StatementType(strings.ToUpper(t.text))
+		}
+	}
+	return "WITH"
+}
+
+func skipSpaceAndComments(sql string) string {
+	for {
+		sql = strings.TrimLeftFunc(sql, unicode.IsSpace)
+		switch {
+		case strings.HasPrefix(sql, "--"):
+			i := strings.IndexByte(sql, '\n')
+			if i < 0 {
+				return ""
+			}
+			sql = sql[i+1:]
+		case strings.HasPrefix(sql, "/*"):
+			i := strings.Index(sql, "*/")
+			if i < 0 {
+				return ""
+			}
+			sql = sql[i+2:]
+		default:
+			return sql
+		}
+	}
+}
+
+// fingerprint identifies a statement independently of its whitespace.
+func fingerprint(sql string) string {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(strings.Fields(sql), " ")))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenPlaceholder
+	tokenPunct
+)
+
+// sqlToken is a lexical token of a statement. For quoted identifiers, text is
+// the unquoted name.
+type sqlToken struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize splits sql into tokens, dropping whitespace and comments. It never
+// fails: unterminated literals and unknown characters are kept as tokens, so
+// malformed SQL still yields a best-effort result.
+func tokenize(sql string) []sqlToken {
+	var tokens []sqlToken
+	for i := 0; i < len(sql); {
+		ch := sql[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f':
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 1
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case ch == '\'':
+			end := quotedEnd(sql, i, '\'')
+			tokens = append(tokens, sqlToken{kind: tokenString, text: sql[i:end]})
+			i = end
+		case ch == '"' || ch == '`':
+			end := quotedEnd(sql, i, ch)
+			tokens = append(tokens, sqlToken{kind: tokenIdent, text: strings.Trim(sql[i:end], string(ch))})
+			i = end
+		case ch == '[':
+			end := strings.IndexByte(sql[i:], ']')
+			if end < 0 {
+				end = len(sql) - i - 1
+			}
+			tokens = append(tokens, sqlToken{kind: tokenIdent, text: strings.Trim(sql[i:i+end+1], "[]")})
+			i += end + 1
+		case ch == '?':
+			tokens = append(tokens, sqlToken{kind: tokenPlaceholder, text: "?"})
+			i++
+		case strings.HasPrefix(sql[i:], "::"):
+			// A PostgreSQL cast, not a :name placeholder.
+			tokens = append(tokens, sqlToken{kind: tokenPunct, text: "::"})
+			i += 2
+		case (ch == '$' || ch == ':' || ch == '@') && i+1 < len(sql) && isWordByte(sql[i+1]):
+			end := i + 1
+			for end < len(sql) && isWordByte(sql[end]) {
+				end++
+			}
+			tokens = append(tokens, sqlToken{kind: tokenPlaceholder, text: sql[i:end]})
+			i = end
+		case ch >= '0' && ch <= '9':
+			end := i
+			for end < len(sql) && (sql[end] >= '0' && sql[end] <= '9' || sql[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, sqlToken{kind: tokenNumber, text: sql[i:end]})
+			i = end
+		case isWordByte(ch):
+			end := i
+			for end < len(sql) && isWordByte(sql[end]) {
+				end++
+			}
+			tokens = append(tokens, sqlToken{kind: tokenWord, text: sql[i:end]})
+			i = end
+		default:
+			tokens = append(tokens, sqlToken{kind: tokenPunct, text: sql[i : i+1]})
+			i++
+		}
+	}
+	return tokens
+}
+
+// quotedEnd returns the index just past the literal opened by quote at
+// sql[start]. Doubled quotes and backslash escapes stay inside the literal.
+func quotedEnd(sql string, start int, quote byte) int {
+	for i := start + 1; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+func isWordByte(ch byte) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch >= 0x80
+}
+
+// isWord reports whether t is the keyword w, ignoring case.
+func (t sqlToken) isWord(w string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, w)
+}
+
+// queryComplexity is a cheap heuristic score of a statement: the number of
+// JOINs and subqueries counts twice, each WHERE/ON condition once.
+func queryComplexity(tokens []sqlToken) int {
+	score := 0
+	for i, t := range tokens {
+		switch {
+		case t.isWord("JOIN"):
+			score += 2
+		case t.isWord("SELECT") && i > 0 && tokens[i-1].kind == tokenPunct && tokens[i-1].text == "(":
+			score += 2
+		case t.isWord("WHERE"), t.isWord("ON"), t.isWord("AND"), t.isWord("OR"):
+			score++
+		}
+	}
+	return score
+}
+
+// SpanNameFromSQL is a span name formatter for WithSpanNameFormatter. It
+// names spans after the verb and main table of the statement, such as
+// "SELECT users", or only the verb if there is no table. Keywords may be in
+// any case, WITH clauses are skipped to the main statement and only the
+// first statement of a multi-statement query is considered. It returns ""
+// for statements without a leading verb.
+func SpanNameFromSQL(sql string, _ []interface{}) string {
+	tokens := tokenize(sql)
+	for _, i := range topLevelIndexes(tokens) {
+		if tokens[i].kind == tokenPunct && tokens[i].text == ";" {
+			tokens = tokens[:i]
+			break
+		}
+	}
+	return statementSpanName(parseTokens(statementTypeOf(sql), tokens))
+}
+
+// statementSpanName returns a span name such as "SELECT users" for a parsed
+// statement, or only the verb if it has no table. It returns "" for
+// statements without a leading verb.
+func statementSpanName(parsed ParsedSQL) string {
+	if parsed.Operation == StatementUnknown {
+		return ""
+	}
+	if parsed.Table != "" {
+		return string(parsed.Operation) + " " + parsed.Table
+	}
+	return string(parsed.Operation)
+}
@@ -0,0 +1,77 @@
+package otelxorm
+
+import (
+	"testing"
+)
+
+func TestQueryComplexity(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want int
+	}{
+		{"no conditions", "SELECT * FROM users", 0},
+		{"where", "SELECT * FROM users WHERE id = ?", 1},
+		{"where and or", "SELECT * FROM users WHERE a = ? AND b = ? OR c = ?", 3},
+		{"join", "SELECT * FROM users u JOIN orders o ON o.user_id = u.id", 3},
+		{"two joins", "SELECT * FROM a JOIN b ON b.a = a.id LEFT JOIN c ON c.b = b.id WHERE a.x = ?", 7},
+		{"subquery", "SELECT * FROM users WHERE id IN (SELECT user_id FROM orders)", 3},
+		{"keywords in strings", "SELECT * FROM users WHERE name = 'JOIN AND OR'", 1},
+		{"keywords in identifiers", "SELECT joined, order_id FROM users", 0},
+		{"unterminated string", "SELECT * FROM users WHERE name = 'bob", 1},
+		{"unbalanced parentheses", "SELECT * FROM (SELECT * FROM users WHERE", 3},
+		{"empty", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := queryComplexity(tokenize(tt.sql)); got != tt.want {
+				t.Errorf("queryComplexity(%q) = %d, want %d", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordComplexity(t *testing.T) {
+	simple := runQuery(t, testQuery{sql: "SELECT * FROM users WHERE id = ?"}, WithRecordComplexity())
+	joined := runQuery(t, testQuery{sql: "SELECT * FROM a JOIN b ON b.a = a.id JOIN c ON c.b = b.id WHERE a.id = ?"}, WithRecordComplexity())
+
+	s, ok := attrValue(simple.Attributes, "db.query.complexity")
+	if !ok {
+		t.Fatal("no db.query.complexity attribute")
+	}
+	j, _ := attrValue(joined.Attributes, "db.query.complexity")
+	if j.AsInt64() <= s.AsInt64() {
+		t.Errorf("join-heavy score %d is not higher than simple score %d", j.AsInt64(), s.AsInt64())
+	}
+
+	off := runQuery(t, testQuery{sql: "SELECT * FROM users WHERE id = ?"})
+	if _, ok := attrValue(off.Attributes, "db.query.complexity"); ok {
+		t.Error("db.query.complexity recorded without WithRecordComplexity")
+	}
+}
+
+func TestSpanNameFromSQL(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT * FROM users WHERE id = ?", "SELECT users"},
+		{"select * from users", "SELECT users"},
+		{"INSERT INTO orders (id) VALUES (?)", "INSERT orders"},
+		{"UPDATE `accounts` SET balance = ?", "UPDATE accounts"},
+		{"DELETE FROM sessions WHERE expires_at < ?", "DELETE sessions"},
+		{"SELECT * FROM app.users", "SELECT app.users"},
+		{"WITH recent AS (SELECT * FROM orders) SELECT * FROM users JOIN recent ON recent.user_id = users.id", "SELECT users"},
+		{"SELECT * FROM users; DELETE FROM orders", "SELECT users"},
+		{"  -- comment\n SELECT * FROM users", "SELECT users"},
+		{"SELECT 1", "SELECT"},
+		{"BEGIN", "BEGIN"},
+		{"(SELECT 1)", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := SpanNameFromSQL(tt.sql, nil); got != tt.want {
+			t.Errorf("SpanNameFromSQL(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
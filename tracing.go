@@ -7,8 +7,13 @@ import (
 	"go.opentelemetry.io/otel/codes"
 	semconv "go.opentelemetry.io/otel/semconv/v1.18.0"
 	"go.opentelemetry.io/otel/trace"
+	"os"
+	"strings"
+	"time"
 	"xorm.io/xorm"
 	"xorm.io/xorm/contexts"
+	"xorm.io/xorm/dialects"
+	"xorm.io/xorm/schemas"
 )
 
 const (
@@ -33,36 +38,205 @@ func Hook(opts ...Option) contexts.Hook {
 			trace.WithInstrumentationVersion(SemVersion()),
 		)
 	}
+	if cfg.fingerprint == nil {
+		cfg.fingerprint = fingerprint
+	}
+	if cfg.errorFilter == nil {
+		cfg.errorFilter = DefaultErrorFilter
+	}
 	if cfg.formatSQL == nil {
 		cfg.formatSQL = defaultFormatSQL
+		cfg.formatSQLName = "default"
+	}
+	if cfg.hostname == "" && cfg.autoHostname {
+		if host, err := os.Hostname(); err == nil {
+			cfg.hostname = host
+		}
+	}
+	if cfg.hostname != "" {
+		cfg.attrs = append(cfg.attrs, semconv.HostName(cfg.hostname))
+	}
+	if cfg.recordClientTimezone {
+		cfg.attrs = append(cfg.attrs, attribute.Key("db.client.timezone").String(timezoneName(cfg.valueFormat.timeLocation)))
 	}
 	for _, attr := range cfg.attrs {
 		if attr.Key == semconv.DBNameKey {
 			cfg.dbName = attr.Value.AsString()
 		}
 	}
+	if cfg.meterProvider != nil {
+		cfg.metrics = newQueryMetrics(cfg.meterProvider, cfg.attrs)
+	}
+	cfg.staticAttrs = make([]attribute.KeyValue, 0, len(cfg.attrs)+1)
+	cfg.staticAttrs = append(cfg.staticAttrs, cfg.attrs...)
+	cfg.staticAttrs = append(cfg.staticAttrs, attribute.Key("go.orm").String("xorm"))
+	cfg.clientStartOpts = []trace.SpanStartOption{trace.WithSpanKind(trace.SpanKindClient)}
 	return &OpenTelemetryHook{
 		config: cfg,
 	}
 }
 
+// WrapEngine adds the hook to e. Unless opts set db.system, it is derived from
+// the dialect of e.
 func WrapEngine(e *xorm.Engine, opts ...Option) {
-	e.AddHook(Hook(opts...))
+	e.AddHook(Hook(append(opts[:len(opts):len(opts)], dialectSystem(e.Dialect()))...))
 }
 
+// WrapEngineGroup adds the hook to eg. Unless opts set db.system, it is
+// derived from the dialect of eg.
 func WrapEngineGroup(eg *xorm.EngineGroup, opts ...Option) {
-	eg.AddHook(Hook(opts...))
+	eg.AddHook(Hook(append(opts[:len(opts):len(opts)], dialectSystem(eg.Dialect()))...))
+}
+
+// dbSystems maps xorm dialects to their db.system attribute.
+var dbSystems = map[schemas.DBType]attribute.KeyValue{
+	schemas.MYSQL:    semconv.DBSystemMySQL,
+	schemas.POSTGRES: semconv.DBSystemPostgreSQL,
+	schemas.SQLITE:   semconv.DBSystemSqlite,
+	schemas.MSSQL:    semconv.DBSystemMSSQL,
+	schemas.ORACLE:   semconv.DBSystemOracle,
+}
+
+// dialectSystem sets the db.system attribute of dialect, if it is known and
+// no db.system attribute was set.
+func dialectSystem(dialect dialects.Dialect) Option {
+	return optionFunc(func(c *config) {
+		for _, attr := range c.attrs {
+			if attr.Key == semconv.DBSystemKey {
+				return
+			}
+		}
+		if dialect == nil || dialect.URI() == nil {
+			return
+		}
+		if system, ok := dbSystems[dialect.URI().DBType]; ok {
+			c.attrs = append(c.attrs, system)
+		}
+	})
 }
 
 func (h *OpenTelemetryHook) BeforeProcess(c *contexts.ContextHook) (context.Context, error) {
+	if h.config.filter != nil && c.SQL != "" && !h.config.filter(c.SQL, c.Args) {
+		if h.config.debugLogger != nil {
+			h.config.debugLogger("otelxorm: %q filtered out, no span started", c.SQL)
+		}
+		if h.config.beforeHook != nil {
+			h.config.beforeHook(c)
+		}
+		return context.WithValue(c.Ctx, filteredKey{}, true), nil
+	}
+	var stmtType StatementType
+	if h.config.migrationSpanName != "" || len(h.config.spanKinds) > 0 {
+		stmtType = statementTypeOf(c.SQL)
+	}
 	spanName := "xorm-db"
-	if len(h.config.dbName) != 0 {
+	if len(h.config.spanName) != 0 {
+		spanName = h.config.spanName
+	} else if len(h.config.dbName) != 0 {
 		spanName = h.config.dbName
 	}
-	ctx, _ := h.config.tracer.Start(c.Ctx,
-		spanName,
-		trace.WithSpanKind(trace.SpanKindClient),
-	)
+	rename := true
+	if h.config.migrationSpanName != "" && stmtType.IsDDL() {
+		spanName = h.config.migrationSpanName
+		rename = false
+	}
+	if rename && h.config.spanNameFormatter != nil && c.SQL != "" {
+		if name := h.config.spanNameFormatter(c.SQL, c.Args); name != "" {
+			spanName = name
+		}
+		rename = false
+	}
+	parentCtx := c.Ctx
+	if h.config.contextKey != nil {
+		if ctx, ok := c.Ctx.Value(h.config.contextKey).(context.Context); ok {
+			parentCtx = ctx
+			if h.config.debugLogger != nil {
+				h.config.debugLogger("otelxorm: parent context taken from context key %v", h.config.contextKey)
+			}
+		}
+	} else if ctx, ok := legacyParentContext(c.Ctx); ok {
+		parentCtx = ctx
+		if h.config.debugLogger != nil {
+			h.config.debugLogger("otelxorm: parent context taken from the deprecated %q context key", legacySpanCtxKey)
+		}
+	}
+	if h.config.debugLogger != nil {
+		if parent := trace.SpanContextFromContext(parentCtx); parent.IsValid() {
+			h.config.debugLogger("otelxorm: parent span %s found for %q", parent.SpanID(), c.SQL)
+		} else {
+			h.config.debugLogger("otelxorm: no parent span for %q, the span is a root span", c.SQL)
+		}
+	}
+	// The shared options have len == cap, so appending to them copies.
+	startOpts := h.config.clientStartOpts
+	if kind, ok := h.config.spanKinds[stmtType]; ok {
+		startOpts = []trace.SpanStartOption{trace.WithSpanKind(kind)}
+	}
+	if h.config.recordParentOperation {
+		if name, ok := parentSpanName(parentCtx); ok {
+			startOpts = append(startOpts, trace.WithAttributes(attribute.Key("db.caller.operation").String(name)))
+		}
+	}
+	if h.config.recordSiblingIndex {
+		if index, ok := siblingIndex(c.Ctx, parentCtx); ok {
+			startOpts = append(startOpts, trace.WithAttributes(attribute.Key("db.sibling_query.index").Int64(index)))
+		}
+	}
+	if isForceSampled(c.Ctx) {
+		startOpts = append(startOpts, trace.WithAttributes(attribute.Key("sampling.priority").Int(1)))
+	}
+	// Decided from the parent's sampled flag, which is also set for remote
+	// parents, before the tracestate mutation replaces the parent span with
+	// a non-recording one.
+	var parentSC trace.SpanContext
+	if h.config.inheritParentSampling {
+		parentSC = trace.SpanContextFromContext(parentCtx)
+	}
+	unsampledParent := h.config.inheritParentSampling && !parentSC.IsSampled()
+	if h.config.traceStateMutator != nil && !unsampledParent {
+		parentCtx = mutateTraceState(parentCtx, h.config.traceStateMutator)
+	}
+	var ctx context.Context
+	pendingFilter := h.config.filter != nil && c.SQL == ""
+	if unsampledParent {
+		// Keep the parent's span context for propagation, without recording.
+		ctx = trace.ContextWithSpanContext(c.Ctx, parentSC)
+		if h.config.debugLogger != nil {
+			h.config.debugLogger("otelxorm: parent span not sampled, no span started for %q", c.SQL)
+		}
+		if pendingFilter {
+			// The filter still decides in AfterProcess whether the query
+			// is dropped, metrics included.
+			ctx = context.WithValue(ctx, filterPendingKey{}, true)
+		}
+	} else if h.config.lazySpanNaming || pendingFilter {
+		if h.config.debugLogger != nil {
+			h.config.debugLogger("otelxorm: span start for %q deferred to AfterProcess", c.SQL)
+		}
+		startOpts = append(startOpts, trace.WithTimestamp(time.Now()))
+		ctx = context.WithValue(c.Ctx, lazySpanKey{}, &lazySpan{
+			parent: parentCtx,
+			name:   spanName,
+			rename: rename && (h.config.lazySpanNaming || h.config.spanNameFormatter != nil),
+			filter: pendingFilter,
+			opts:   startOpts,
+		})
+	} else {
+		_, span := h.config.tracer.Start(parentCtx, spanName, startOpts...)
+		ctx = c.Ctx
+		if span.IsRecording() || span.SpanContext().IsValid() || hasSpan(c.Ctx) {
+			ctx = trace.ContextWithSpan(c.Ctx, span)
+		}
+		if h.config.debugLogger != nil {
+			h.config.debugLogger("otelxorm: span %q started for %q, recording: %t", spanName, c.SQL, span.IsRecording())
+		}
+		if rename && h.config.spanNameFormatter != nil {
+			ctx = context.WithValue(ctx, renameSpanKey{}, true)
+		}
+		if h.config.heartbeatInterval > 0 && span.IsRecording() {
+			ctx = context.WithValue(ctx, heartbeatKey{}, startHeartbeat(c.Ctx, span, h.config.heartbeatInterval))
+		}
+	}
 	if h.config.beforeHook != nil {
 		h.config.beforeHook(c)
 	}
@@ -70,17 +244,292 @@ func (h *OpenTelemetryHook) BeforeProcess(c *contexts.ContextHook) (context.Cont
 }
 
 func (h *OpenTelemetryHook) AfterProcess(c *contexts.ContextHook) error {
+	var lazy *lazySpan
+	var isLazy, filtered, pending bool
+	if h.config.lazySpanNaming || h.config.filter != nil {
+		lazy, isLazy = c.Ctx.Value(lazySpanKey{}).(*lazySpan)
+	}
+	if h.config.filter != nil {
+		filtered, _ = c.Ctx.Value(filteredKey{}).(bool)
+		pending, _ = c.Ctx.Value(filterPendingKey{}).(bool)
+		pending = pending || isLazy && lazy.filter
+	}
+	if filtered || pending && !h.config.filter(c.SQL, c.Args) {
+		drainQueryAttributes(c.Ctx)
+		if h.config.afterHook != nil {
+			h.config.afterHook(c)
+		}
+		return nil
+	}
 	span := trace.SpanFromContext(c.Ctx)
-	attrs := make([]attribute.KeyValue, 0)
+	// The statement is only classified when something uses its type.
+	var stmtType StatementType
+	if isLazy || span.IsRecording() || h.config.metrics != nil || h.config.recordPreparedReuse {
+		stmtType = statementTypeOf(c.SQL)
+	}
+	q := newQuery(c.SQL, stmtType, h.config.parser)
+	if isLazy {
+		name := lazy.name
+		if lazy.rename {
+			if stmtName := h.statementSpanName(c, &q); stmtName != "" {
+				name = stmtName
+			}
+		}
+		_, span = h.config.tracer.Start(lazy.parent, name, lazy.opts...)
+	} else if h.config.spanNameFormatter != nil {
+		if rename, _ := c.Ctx.Value(renameSpanKey{}).(bool); rename {
+			if name := h.config.spanNameFormatter(c.SQL, c.Args); name != "" {
+				span.SetName(name)
+			}
+		}
+	}
 	defer span.End()
+	if h.config.heartbeatInterval > 0 {
+		stopHeartbeat(c.Ctx)
+	}
+	reuse, hasReuse := h.preparedReuse(c, stmtType)
+	failed := c.Err != nil && h.config.errorFilter(c.Err)
+	if h.config.metrics != nil {
+		h.config.metrics.record(c, stmtType, failed)
+	}
+	if !span.IsRecording() {
+		// Nothing is exported for this span: skip formatting the statement
+		// and building attributes, which dominate the cost of the hook.
+		if h.config.debugLogger != nil {
+			h.config.debugLogger("otelxorm: span for %q not recording, no attributes set", c.SQL)
+		}
+		drainQueryAttributes(c.Ctx)
+		if h.config.afterHook != nil {
+			h.config.afterHook(c)
+		}
+		return nil
+	}
 
-	attrs = append(attrs, h.config.attrs...)
-	attrs = append(attrs, attribute.Key("go.orm").String("xorm"))
-	attrs = append(attrs, semconv.DBStatement(h.config.formatSQL(c.SQL, c.Args)))
+	attrs := make([]attribute.KeyValue, 0, len(h.config.staticAttrs)+4)
+	attrs = append(attrs, h.config.staticAttrs...)
+	args := c.Args
+	if h.config.argsRedactor != nil && !h.config.redactArgs {
+		args = h.config.argsRedactor(args)
+	}
+	if !h.config.disableStatement {
+		statement := c.SQL
+		if h.config.formatsStatement() {
+			statement = h.config.statementFormatter(stmtType)(c.SQL, args)
+		}
+		if strings.TrimSpace(statement) != "" {
+			attrs = append(attrs, semconv.DBStatement(h.config.truncate(statement)))
+		}
+	}
+	if h.config.normalizer != nil && !h.config.disableStatement {
+		attrs = append(attrs, attribute.Key("db.statement.normalized").String(h.config.truncate(h.config.normalizer.Normalize(c.SQL))))
+	}
+	if h.config.recordAppCaller {
+		attrs = append(attrs, appCallerAttributes()...)
+	}
+	if h.config.structuredAttrs && stmtType != StatementUnknown {
+		attrs = append(attrs, semconv.DBOperation(string(stmtType)))
+		if stmtType == StatementSelect || stmtType.IsWrite() || stmtType.IsDDL() {
+			if table := q.parse().Table; table != "" {
+				attrs = append(attrs, semconv.DBSQLTable(table))
+			}
+		}
+	}
+	if h.config.xormMethodFunc != nil {
+		if method, ok := h.config.xormMethodFunc(c); ok {
+			attrs = append(attrs, attribute.Key("db.xorm.method").String(method))
+		}
+	}
+	if h.config.recordExecPath {
+		exec := c.Result != nil || c.Err != nil && (stmtType.IsWrite() || stmtType.IsDDL())
+		attrs = append(attrs, attribute.Key("db.xorm.exec").Bool(exec))
+	}
+	if h.config.recordArgs && !h.config.redactArgs {
+		attrs = append(attrs, h.config.argAttributes(args)...)
+	} else if len(h.config.recordArgValues) > 0 && !h.config.redactArgs {
+		attrs = append(attrs, h.config.argValueAttributes(args)...)
+	}
+	if h.config.recordArgsJSON && !h.config.redactArgs && len(args) > 0 {
+		attrs = append(attrs, h.config.argsJSON(args))
+	}
+	if h.config.tagMigrations && stmtType.IsDDL() {
+		attrs = append(attrs, attribute.Key("db.migration").Bool(true))
+	}
+	if version, ok := h.config.serverVersion(); ok {
+		attrs = append(attrs, attribute.Key("db.version").String(version))
+	}
+	if version, ok := h.config.currentSchemaVersion(); ok {
+		attrs = append(attrs, attribute.Key("db.schema.version").String(version))
+	}
+	if h.config.massMutationThreshold > 0 && stmtType.IsWrite() && c.Result != nil {
+		if affected, err := c.Result.RowsAffected(); err == nil && affected > h.config.massMutationThreshold {
+			attrs = append(attrs, attribute.Key("db.mass_mutation").Bool(true))
+			span.AddEvent("mass_mutation", trace.WithAttributes(
+				attribute.Key("db.rows.affected").Int64(affected),
+				attribute.Key("db.mass_mutation.threshold").Int64(h.config.massMutationThreshold),
+			))
+		}
+	}
+	if stmtType.IsWrite() && c.Result != nil {
+		if affected, err := c.Result.RowsAffected(); err == nil {
+			attrs = append(attrs, attribute.Key("db.rows.affected").Int64(affected))
+		}
+	} else if stmtType == StatementSelect && h.config.readCountFunc != nil {
+		if returned, ok := h.config.readCountFunc(c); ok {
+			attrs = append(attrs, attribute.Key("db.rows.returned").Int64(returned))
+		}
+	}
+	if h.config.unifiedRowCount {
+		if n, ok := h.config.rowCount(c, stmtType); ok {
+			attrs = append(attrs, attribute.Key("db.rows").Int64(n))
+		}
+	}
+	if hasReuse {
+		attrs = append(attrs, attribute.Key("db.prepared.reuse").Int64(reuse))
+	}
+	if h.config.columnCountFunc != nil {
+		if n, ok := h.config.columnCountFunc(c); ok {
+			attrs = append(attrs, attribute.Key("db.columns.count").Int(n))
+		}
+	}
+	if h.config.queryPlanFunc != nil {
+		if plan, ok := h.config.queryPlanFunc(c); ok {
+			span.AddEvent("db.query.plan", trace.WithAttributes(
+				attribute.Key("db.query.plan").String(h.config.truncate(plan)),
+			))
+		}
+	}
+	if h.config.recordComplexity {
+		attrs = append(attrs, attribute.Key("db.query.complexity").Int(queryComplexity(q.tokenize())))
+	}
+	if threshold := h.config.slowThresholdFor(stmtType); threshold > 0 && c.ExecuteTime > threshold {
+		attrs = append(attrs, attribute.Key("db.slow").Bool(true))
+		span.AddEvent("slow_query", trace.WithAttributes(
+			attribute.Key("db.duration_ms").Float64(durationMs(c.ExecuteTime)),
+			attribute.Key("db.slow.threshold_ms").Float64(durationMs(threshold)),
+		))
+	}
+	if h.config.queueTimeFunc != nil {
+		if d, ok := h.config.queueTimeFunc(c.Ctx); ok {
+			attrs = append(attrs, attribute.Key("db.queue_ms").Float64(durationMs(d)))
+		}
+	}
+	if h.config.serverTimeFunc != nil {
+		if d, ok := h.config.serverTimeFunc(c); ok {
+			attrs = append(attrs, attribute.Key("db.server.processing_ms").Float64(durationMs(d)))
+		}
+	}
+	if h.config.resultSizeFunc != nil {
+		if size, ok := h.config.resultSizeFunc(c); ok {
+			attrs = append(attrs, attribute.Key("db.response.body.size").Int64(size))
+		}
+	}
+	if h.config.txIDFunc != nil {
+		if id, ok := h.config.txIDFunc(c.Ctx); ok {
+			attrs = append(attrs, attribute.Key("db.transaction.id").String(id))
+		}
+	}
+	if h.config.upstreamCacheStatusFunc != nil {
+		if status, ok := h.config.upstreamCacheStatusFunc(c.Ctx); ok {
+			attrs = append(attrs, attribute.Key("cache.status").String(status))
+		}
+	}
+	if h.config.connectionReusedFunc != nil {
+		if reused, ok := h.config.connectionReusedFunc(c); ok {
+			attrs = append(attrs, attribute.Key("db.connection.reused").Bool(reused))
+		}
+	}
+	if h.config.batchPositionFunc != nil {
+		if index, total, ok := h.config.batchPositionFunc(c.Ctx); ok {
+			attrs = append(attrs,
+				attribute.Key("db.batch.index").Int(index),
+				attribute.Key("db.batch.total").Int(total),
+			)
+		}
+	}
+	if h.config.replicaInfoFunc != nil {
+		if name, lagMs, ok := h.config.replicaInfoFunc(c); ok {
+			attrs = append(attrs,
+				attribute.Key("db.replica").String(name),
+				attribute.Key("db.replica.lag_ms").Int64(lagMs),
+			)
+		}
+	}
+	if h.config.recordLimit {
+		if limit, ok := limitOf(q.tokenize(), c.Args); ok {
+			attrs = append(attrs, attribute.Key("db.limit").Int64(limit))
+		}
+	}
+	if h.config.recordOffset {
+		if offset, ok := offsetOf(q.tokenize(), c.Args); ok {
+			attrs = append(attrs, attribute.Key("db.offset").Int64(offset))
+			if h.config.deepPaginationThreshold > 0 && offset > int64(h.config.deepPaginationThreshold) {
+				attrs = append(attrs, attribute.Key("db.deep_pagination").Bool(true))
+			}
+		}
+	}
+	if h.config.recordSortColumns {
+		if columns := q.parse().SortColumns; len(columns) > 0 {
+			attrs = append(attrs, attribute.Key("db.sort.columns").StringSlice(columns))
+		}
+	}
+	if h.config.flagIndexUnfriendly && indexUnfriendly(q.tokenize(), c.Args) {
+		attrs = append(attrs, attribute.Key("db.index_unfriendly").Bool(true))
+	}
+	if h.config.recordJoinCount {
+		attrs = append(attrs, attribute.Key("db.join.count").Int(q.parse().JoinCount))
+	}
+	if h.config.idempotencyClassifier != nil {
+		attrs = append(attrs, attribute.Key("db.idempotent").Bool(h.config.idempotencyClassifier(c, q.parse())))
+	}
+	if h.config.flagUnparameterized && (stmtType == StatementSelect || stmtType.IsWrite()) {
+		attrs = append(attrs, attribute.Key("db.parameterized").Bool(parameterized(q.tokenize(), c.Args)))
+	}
+	if h.config.fingerprintSamples != nil && stmtType != StatementUnknown {
+		fp := h.config.fingerprint(c.SQL)
+		if sample, ok := h.config.fingerprintSamples.collides(fp, c.SQL); ok {
+			attrs = append(attrs, attribute.Key("db.fingerprint.collision").Bool(true))
+			eventAttrs := []attribute.KeyValue{attribute.Key("db.fingerprint").String(fp)}
+			if !h.config.disableStatement {
+				// The sample is another statement's SQL.
+				eventAttrs = append(eventAttrs, attribute.Key("db.fingerprint.sample").String(h.config.truncate(sample)))
+			}
+			span.AddEvent("db.fingerprint.collision", trace.WithAttributes(eventAttrs...))
+		}
+	}
+	if h.config.recordPaginationStyle && stmtType == StatementSelect {
+		attrs = append(attrs, attribute.Key("db.pagination.style").String(paginationStyle(q.tokenize(), q.parse())))
+	}
+	for _, sa := range h.config.statementAttrs {
+		if v := sa.extractor(q.parse()); v != "" {
+			attrs = append(attrs, sa.key.String(h.config.truncate(v)))
+		}
+	}
+	if len(h.config.tableAttrs) > 0 {
+		attrs = append(attrs, h.config.tableAttributes(q.parse().Tables)...)
+	}
+	for _, cond := range h.config.conditionalAttrs {
+		if cond.pred(c) {
+			attrs = append(attrs, cond.attrs...)
+		}
+	}
+	attrs = append(attrs, drainQueryAttributes(c.Ctx)...)
 
-	if c.Err != nil {
+	if failed {
 		span.RecordError(c.Err)
 		span.SetStatus(codes.Error, c.Err.Error())
+		attrs = append(attrs, h.config.errorAttrs...)
+		if h.config.recordErrorType {
+			attrs = append(attrs, attribute.Key("db.error.type").String(h.config.errorType(c.Err)))
+		}
+		if h.config.recordValuesOnConstraintError && !h.config.redactArgs && len(args) > 0 && isConstraintViolation(c.Err) {
+			values := make([]string, len(args))
+			for i, arg := range args {
+				values[i] = h.config.valueFormat.formatValue(arg)
+			}
+			span.AddEvent("db.constraint_violation", trace.WithAttributes(
+				attribute.Key("db.args").StringSlice(values),
+			))
+		}
 	}
 	span.SetAttributes(attrs...)
 	if h.config.afterHook != nil {
@@ -88,3 +537,59 @@ func (h *OpenTelemetryHook) AfterProcess(c *contexts.ContextHook) error {
 	}
 	return nil
 }
+
+// statementSpanName names a span after its statement, with the configured
+// formatter or the parsed statement.
+func (h *OpenTelemetryHook) statementSpanName(c *contexts.ContextHook, q *query) string {
+	if h.config.spanNameFormatter != nil {
+		return h.config.spanNameFormatter(c.SQL, c.Args)
+	}
+	return statementSpanName(q.parse())
+}
+
+// preparedReuse counts the execution of c in its prepared reuse scope, even
+// for spans that are not recorded, and returns the number of earlier
+// executions.
+func (h *OpenTelemetryHook) preparedReuse(c *contexts.ContextHook, stmtType StatementType) (int64, bool) {
+	if !h.config.recordPreparedReuse || stmtType == StatementUnknown || c.SQL == "PREPARE" {
+		return 0, false
+	}
+	return preparedReuse(c.Ctx, h.config.fingerprint(c.SQL))
+}
+
+// hasSpan reports whether ctx carries a span that may record or propagate.
+// Without one, a non-recording span with an invalid span context, as started
+// by a no-op tracer, need not be stored in the query context: AfterProcess
+// gets the same no-op span back from trace.SpanFromContext.
+func hasSpan(ctx context.Context) bool {
+	span := trace.SpanFromContext(ctx)
+	return span.IsRecording() || span.SpanContext().IsValid()
+}
+
+// parentSpanName returns the name of the span in ctx, if it has one.
+func parentSpanName(ctx context.Context) (string, bool) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.SpanContext().IsValid() {
+		return "", false
+	}
+	named, ok := parent.(interface{ Name() string })
+	if !ok || named.Name() == "" {
+		return "", false
+	}
+	return named.Name(), true
+}
+
+// mutateTraceState returns ctx with the tracestate of its span context
+// replaced by fn's result, or ctx itself if the result is invalid.
+func mutateTraceState(ctx context.Context, fn func(ts trace.TraceState) trace.TraceState) context.Context {
+	sc := trace.SpanContextFromContext(ctx)
+	ts, err := trace.ParseTraceState(fn(sc.TraceState()).String())
+	if err != nil {
+		return ctx
+	}
+	return trace.ContextWithSpanContext(ctx, sc.WithTraceState(ts))
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,640 @@
+package otelxorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"reflect"
+	"testing"
+	"time"
+	"xorm.io/xorm/contexts"
+)
+
+// newTestHook returns a hook exporting its spans to the returned exporter.
+func newTestHook(opts ...Option) (*OpenTelemetryHook, *tracetest.InMemoryExporter) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	h := Hook(append([]Option{WithTracerProvider(tp)}, opts...)...).(*OpenTelemetryHook)
+	return h, exp
+}
+
+// testQuery is a query run through a hook the way xorm runs it.
+type testQuery struct {
+	ctx    context.Context
+	sql    string
+	args   []interface{}
+	result sql.Result
+	err    error
+	// duration overrides the measured execution time if set.
+	duration time.Duration
+}
+
+func (q testQuery) run(t *testing.T, h contexts.Hook) {
+	t.Helper()
+	ctx := q.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	c := contexts.NewContextHook(ctx, q.sql, q.args)
+	ctx, err := h.BeforeProcess(c)
+	if err != nil {
+		t.Fatalf("BeforeProcess: %v", err)
+	}
+	c.End(ctx, q.result, q.err)
+	if q.duration > 0 {
+		c.ExecuteTime = q.duration
+	}
+	if err := h.AfterProcess(c); err != nil {
+		t.Fatalf("AfterProcess: %v", err)
+	}
+}
+
+// runQuery runs q through a new hook configured with opts and returns the
+// span it exported.
+func runQuery(t *testing.T, q testQuery, opts ...Option) tracetest.SpanStub {
+	t.Helper()
+	h, exp := newTestHook(opts...)
+	q.run(t, h)
+	spans := exp.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	return spans[0]
+}
+
+// attrValue returns the value of the attribute key in attrs.
+func attrValue(attrs []attribute.KeyValue, key attribute.Key) (attribute.Value, bool) {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+// eventNamed returns the event of span called name.
+func eventNamed(span tracetest.SpanStub, name string) (sdktrace.Event, bool) {
+	for _, event := range span.Events {
+		if event.Name == name {
+			return event, true
+		}
+	}
+	return sdktrace.Event{}, false
+}
+
+// rowsAffected is a sql.Result of a write affecting that many rows.
+type rowsAffected int64
+
+func (r rowsAffected) LastInsertId() (int64, error) {
+	return 0, errors.New("LastInsertId is not supported")
+}
+
+func (r rowsAffected) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+func TestTagMigrations(t *testing.T) {
+	tests := []struct {
+		name         string
+		sql          string
+		wantTagged   bool
+		wantSpanName string
+	}{
+		{"create table", "CREATE TABLE users (id INT PRIMARY KEY)", true, "migration"},
+		{"alter table", "alter table users add column name varchar(255)", true, "migration"},
+		{"drop table after comment", "/* v2 */ DROP TABLE users", true, "migration"},
+		{"select", "SELECT * FROM users", false, "app"},
+		{"insert", "INSERT INTO users (id) VALUES (?)", false, "app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql}, WithDBName("app"), WithMigrationSpanName("migration"))
+			v, ok := attrValue(span.Attributes, "db.migration")
+			if ok != tt.wantTagged || ok && !v.AsBool() {
+				t.Errorf("db.migration = %v (present: %t), want present: %t", v.Emit(), ok, tt.wantTagged)
+			}
+			if span.Name != tt.wantSpanName {
+				t.Errorf("span name = %q, want %q", span.Name, tt.wantSpanName)
+			}
+		})
+	}
+}
+
+func TestTagMigrationsWithoutSpanName(t *testing.T) {
+	span := runQuery(t, testQuery{sql: "CREATE TABLE users (id INT)"}, WithTagMigrations())
+	if v, ok := attrValue(span.Attributes, "db.migration"); !ok || !v.AsBool() {
+		t.Errorf("db.migration = %v, want true", v.Emit())
+	}
+	if span.Name != "xorm-db" {
+		t.Errorf("span name = %q, want the default name", span.Name)
+	}
+}
+
+func TestStatementType(t *testing.T) {
+	tests := []struct {
+		sql     string
+		want    StatementType
+		wantDDL bool
+	}{
+		{"CREATE TABLE t (id INT)", StatementCreate, true},
+		{"  alter table t add c int", StatementAlter, true},
+		{"-- migrate\nDROP INDEX i", StatementDrop, true},
+		{"TRUNCATE t", StatementTruncate, true},
+		{"RENAME TABLE a TO b", StatementRename, true},
+		{"select 1", StatementSelect, false},
+		{"UPDATE t SET a = 1", StatementUpdate, false},
+		{"BEGIN", "BEGIN", false},
+		{"", StatementUnknown, false},
+	}
+	for _, tt := range tests {
+		got := statementTypeOf(tt.sql)
+		if got != tt.want || got.IsDDL() != tt.wantDDL {
+			t.Errorf("statementTypeOf(%q) = %q (DDL: %t), want %q (DDL: %t)", tt.sql, got, got.IsDDL(), tt.want, tt.wantDDL)
+		}
+	}
+}
+
+func TestRecordParentOperation(t *testing.T) {
+	h, exp := newTestHook(WithRecordParentOperation())
+	ctx, parent := h.config.tracerProvider.Tracer("test").Start(context.Background(), "GET /users")
+	testQuery{ctx: ctx, sql: "SELECT * FROM users"}.run(t, h)
+	parent.End()
+	testQuery{sql: "SELECT * FROM users"}.run(t, h)
+
+	spans := exp.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	if v, ok := attrValue(spans[0].Attributes, "db.caller.operation"); !ok || v.AsString() != "GET /users" {
+		t.Errorf("db.caller.operation = %q, want GET /users", v.Emit())
+	}
+	if _, ok := attrValue(spans[2].Attributes, "db.caller.operation"); ok {
+		t.Error("db.caller.operation is set without a parent span")
+	}
+}
+
+func TestTraceStateMutator(t *testing.T) {
+	addRouting := func(ts trace.TraceState) trace.TraceState {
+		ts, _ = ts.Insert("vendor", "db")
+		return ts
+	}
+	tests := []struct {
+		name     string
+		parentTS string
+		mutator  func(ts trace.TraceState) trace.TraceState
+		want     string
+	}{
+		{"no parent", "", addRouting, "vendor=db"},
+		{"parent tracestate kept", "other=1", addRouting, "vendor=db,other=1"},
+		{"parent tracestate cleared", "other=1", func(trace.TraceState) trace.TraceState {
+			return trace.TraceState{}
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, exp := newTestHook(WithTraceStateMutator(tt.mutator))
+			ctx := context.Background()
+			if tt.parentTS != "" {
+				ts, err := trace.ParseTraceState(tt.parentTS)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ctx = trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+					TraceID:    trace.TraceID{1},
+					SpanID:     trace.SpanID{1},
+					TraceFlags: trace.FlagsSampled,
+					TraceState: ts,
+					Remote:     true,
+				}))
+			}
+			testQuery{ctx: ctx, sql: "SELECT 1"}.run(t, h)
+
+			spans := exp.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			if got := spans[0].SpanContext.TraceState().String(); got != tt.want {
+				t.Errorf("tracestate = %q, want %q", got, tt.want)
+			}
+			if tt.parentTS != "" && spans[0].Parent.SpanID() != (trace.SpanID{1}) {
+				t.Errorf("parent span ID = %s, want the remote parent", spans[0].Parent.SpanID())
+			}
+		})
+	}
+}
+
+func TestLazySpanNaming(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		sql  string
+		want string
+	}{
+		{"select", []Option{WithLazySpanNaming()}, "SELECT * FROM users WHERE id = ?", "SELECT users"},
+		{"update", []Option{WithLazySpanNaming()}, "UPDATE orders SET paid = ?", "UPDATE orders"},
+		{"no verb keeps the default", []Option{WithLazySpanNaming(), WithDBName("app")}, "(SELECT 1)", "app"},
+		{"formatter", []Option{WithLazySpanNaming(), WithSpanNameFormatter(func(sql string, args []interface{}) string {
+			return "custom"
+		})}, "SELECT * FROM users", "custom"},
+		{"migration name wins", []Option{WithLazySpanNaming(), WithTagMigrations(), WithMigrationSpanName("migration")}, "CREATE TABLE users (id INT)", "migration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: tt.sql}, tt.opts...)
+			if span.Name != tt.want {
+				t.Errorf("span name = %q, want %q", span.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestLazySpanNamingSQLKnownAfterStart(t *testing.T) {
+	h, exp := newTestHook(WithLazySpanNaming())
+	before := time.Now()
+	c := contexts.NewContextHook(context.Background(), "", nil)
+	ctx, err := h.BeforeProcess(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	if trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		t.Error("span in the context before AfterProcess")
+	}
+	time.Sleep(time.Millisecond)
+	c.SQL = "DELETE FROM sessions WHERE expires_at < ?"
+	c.End(ctx, nil, nil)
+	if err := h.AfterProcess(c); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exp.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if spans[0].Name != "DELETE sessions" {
+		t.Errorf("span name = %q, want %q", spans[0].Name, "DELETE sessions")
+	}
+	if start := spans[0].StartTime; start.Before(before) || start.After(started) {
+		t.Errorf("span started at %v, want the BeforeProcess time between %v and %v", start, before, started)
+	}
+}
+
+func benchmarkHook(b *testing.B, sampler sdktrace.Sampler) {
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(sampler), sdktrace.WithSyncer(tracetest.NewNoopExporter()))
+	h := Hook(WithTracerProvider(tp))
+	args := []interface{}{42, "bob", true}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := contexts.NewContextHook(context.Background(), "SELECT * FROM users WHERE id = ? AND name = ? AND active = ?", args)
+		ctx, _ := h.BeforeProcess(c)
+		c.End(ctx, nil, nil)
+		_ = h.AfterProcess(c)
+	}
+}
+
+// BenchmarkHook_Unsampled measures the hook for queries whose spans are not
+// sampled, the case AfterProcess short-circuits.
+func BenchmarkHook_Unsampled(b *testing.B) {
+	benchmarkHook(b, sdktrace.NeverSample())
+}
+
+// BenchmarkHook_Sampled measures the hook for parent-less queries under the
+// default sampler of the SDK, which records them.
+func BenchmarkHook_Sampled(b *testing.B) {
+	benchmarkHook(b, sdktrace.ParentBased(sdktrace.AlwaysSample()))
+}
+
+// BenchmarkHook_Default measures the hook built with no options, under the
+// global no-op tracer provider, for parent-less queries: the fast path.
+func BenchmarkHook_Default(b *testing.B) {
+	h := Hook()
+	args := []interface{}{42, "bob", true}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := contexts.NewContextHook(context.Background(), "SELECT * FROM users WHERE id = ? AND name = ? AND active = ?", args)
+		ctx, _ := h.BeforeProcess(c)
+		c.End(ctx, nil, nil)
+		_ = h.AfterProcess(c)
+	}
+}
+
+func TestDefaultFastPath(t *testing.T) {
+	noop := trace.NewNoopTracerProvider()
+	h := Hook(WithTracerProvider(noop)).(*OpenTelemetryHook)
+	c := contexts.NewContextHook(context.Background(), "SELECT * FROM users WHERE id = ?", []interface{}{42})
+	ctx, _ := h.BeforeProcess(c)
+	if ctx != c.Ctx {
+		t.Error("BeforeProcess wrapped the context of a parent-less query in a no-op span")
+	}
+
+	// The hook allocates nothing beyond what the tracer's Start does.
+	tracer := noop.Tracer("test")
+	startAllocs := testing.AllocsPerRun(100, func() {
+		tracer.Start(context.Background(), "xorm-db", trace.WithSpanKind(trace.SpanKindClient))
+	})
+	hookAllocs := testing.AllocsPerRun(100, func() {
+		ctx, _ := h.BeforeProcess(c)
+		c.Ctx = ctx
+		_ = h.AfterProcess(c)
+		c.Ctx = context.Background()
+	})
+	if hookAllocs > startAllocs {
+		t.Errorf("hook allocates %v times per query, want at most the %v of the tracer", hookAllocs, startAllocs)
+	}
+}
+
+func TestNoopTracerKeepsTheParentSpan(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	parentCtx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	h := Hook(WithTracerProvider(trace.NewNoopTracerProvider()))
+	testQuery{ctx: parentCtx, sql: "SELECT 1"}.run(t, h)
+	if !parent.IsRecording() {
+		t.Fatal("the query ended its parent span")
+	}
+	parent.End()
+	if spans := exp.GetSpans(); len(spans) != 1 || len(spans[0].Attributes) != 0 {
+		t.Errorf("got spans %+v, want the parent only, without attributes", spans)
+	}
+}
+
+func TestInheritParentSampling(t *testing.T) {
+	remote := func(flags trace.TraceFlags) context.Context {
+		return trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{1},
+			SpanID:     trace.SpanID{1},
+			TraceFlags: flags,
+			Remote:     true,
+		}))
+	}
+	addRouting := WithTraceStateMutator(func(ts trace.TraceState) trace.TraceState {
+		ts, _ = ts.Insert("vendor", "db")
+		return ts
+	})
+	tests := []struct {
+		name      string
+		opts      []Option
+		ctx       func(h *OpenTelemetryHook) context.Context
+		wantSpans int
+	}{
+		{"no parent", nil, func(*OpenTelemetryHook) context.Context { return context.Background() }, 0},
+		{"sampled local parent", nil, func(h *OpenTelemetryHook) context.Context {
+			ctx, _ := h.config.tracerProvider.Tracer("test").Start(context.Background(), "parent")
+			return ctx
+		}, 1},
+		{"unsampled local parent", nil, func(h *OpenTelemetryHook) context.Context {
+			return trace.ContextWithSpan(context.Background(), trace.SpanFromContext(remote(0)))
+		}, 0},
+		{"sampled remote parent", nil, func(*OpenTelemetryHook) context.Context { return remote(trace.FlagsSampled) }, 1},
+		{"unsampled remote parent", nil, func(*OpenTelemetryHook) context.Context { return remote(0) }, 0},
+		{"sampled remote parent with tracestate mutator", []Option{addRouting}, func(*OpenTelemetryHook) context.Context {
+			return remote(trace.FlagsSampled)
+		}, 1},
+		{"sampled local parent with tracestate mutator", []Option{addRouting}, func(h *OpenTelemetryHook) context.Context {
+			ctx, _ := h.config.tracerProvider.Tracer("test").Start(context.Background(), "parent")
+			return ctx
+		}, 1},
+		{"unsampled remote parent with tracestate mutator", []Option{addRouting}, func(*OpenTelemetryHook) context.Context {
+			return remote(0)
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, exp := newTestHook(append([]Option{WithInheritParentSampling()}, tt.opts...)...)
+			ctx := tt.ctx(h)
+			c := contexts.NewContextHook(ctx, "SELECT 1", nil)
+			queryCtx, err := h.BeforeProcess(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := trace.SpanContextFromContext(queryCtx).TraceID(), trace.SpanContextFromContext(ctx).TraceID(); want.IsValid() && got != want {
+				t.Errorf("query context trace ID = %s, want the parent's %s", got, want)
+			}
+			c.End(queryCtx, nil, nil)
+			if err := h.AfterProcess(c); err != nil {
+				t.Fatal(err)
+			}
+
+			var spans tracetest.SpanStubs
+			for _, span := range exp.GetSpans() {
+				if span.Name != "parent" {
+					spans = append(spans, span)
+				}
+			}
+			if len(spans) != tt.wantSpans {
+				t.Fatalf("got %d database spans, want %d", len(spans), tt.wantSpans)
+			}
+			if tt.wantSpans == 1 && spans[0].Parent.TraceID() != trace.SpanContextFromContext(ctx).TraceID() {
+				t.Errorf("database span is not a child of the parent")
+			}
+		})
+	}
+}
+
+func TestSpanName(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"default", nil, "xorm-db"},
+		{"db name", []Option{WithDBName("app")}, "app"},
+		{"span name", []Option{WithSpanName("xorm.query")}, "xorm.query"},
+		{"span name wins over db name", []Option{WithDBName("app"), WithSpanName("xorm.query")}, "xorm.query"},
+		{"lazy naming keeps it for statements without a verb", []Option{WithSpanName("xorm.query"), WithLazySpanNaming()}, "xorm.query"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := runQuery(t, testQuery{sql: "(SELECT 1)"}, tt.opts...)
+			if span.Name != tt.want {
+				t.Errorf("span name = %q, want %q", span.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpanNameFormatter(t *testing.T) {
+	h, exp := newTestHook(WithSpanNameFormatter(SpanNameFromSQL))
+	testQuery{sql: "SELECT * FROM users"}.run(t, h)
+	testQuery{sql: "(SELECT 1)"}.run(t, h)
+
+	// Without the SQL in BeforeProcess, the span is renamed in AfterProcess.
+	c := contexts.NewContextHook(context.Background(), "", nil)
+	ctx, err := h.BeforeProcess(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SQL = "UPDATE orders SET paid = ?"
+	c.End(ctx, nil, nil)
+	if err := h.AfterProcess(c); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exp.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	for i, want := range []string{"SELECT users", "xorm-db", "UPDATE orders"} {
+		if spans[i].Name != want {
+			t.Errorf("span %d name = %q, want %q", i, spans[i].Name, want)
+		}
+	}
+}
+
+func TestParentSpan(t *testing.T) {
+	type spanCtxKey struct{}
+	tests := []struct {
+		name string
+		opts []Option
+		// ctx returns the query context and the expected parent.
+		ctx func(parent context.Context) context.Context
+	}{
+		{"query context", nil, func(parent context.Context) context.Context { return parent }},
+		{"context key", []Option{WithContextKey(spanCtxKey{})}, func(parent context.Context) context.Context {
+			return context.WithValue(context.Background(), spanCtxKey{}, parent)
+		}},
+		{"legacy spanCtx key", []Option{WithContextKey("spanCtx")}, func(parent context.Context) context.Context {
+			return context.WithValue(context.Background(), "spanCtx", parent)
+		}},
+		{"context key without a value", []Option{WithContextKey(spanCtxKey{})}, func(parent context.Context) context.Context { return parent }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, exp := newTestHook(tt.opts...)
+			parentCtx, parent := h.config.tracerProvider.Tracer("test").Start(context.Background(), "parent")
+			testQuery{ctx: tt.ctx(parentCtx), sql: "SELECT 1"}.run(t, h)
+			parent.End()
+
+			spans := exp.GetSpans()
+			if len(spans) != 2 {
+				t.Fatalf("got %d spans, want 2", len(spans))
+			}
+			query := spans[0]
+			if query.SpanContext.TraceID() != parent.SpanContext().TraceID() {
+				t.Errorf("trace ID = %s, want the parent's %s", query.SpanContext.TraceID(), parent.SpanContext().TraceID())
+			}
+			if query.Parent.SpanID() != parent.SpanContext().SpanID() {
+				t.Errorf("parent span ID = %s, want %s", query.Parent.SpanID(), parent.SpanContext().SpanID())
+			}
+		})
+	}
+}
+
+func TestRootSpan(t *testing.T) {
+	span := runQuery(t, testQuery{sql: "SELECT 1"})
+	if span.Parent.IsValid() {
+		t.Errorf("parent = %s, want a root span", span.Parent.SpanID())
+	}
+	if !span.SpanContext.IsValid() {
+		t.Error("invalid span context")
+	}
+}
+
+func TestDebugLogger(t *testing.T) {
+	rejectPing := WithFilter(func(sql string, args []interface{}) bool { return sql != "SELECT 1" })
+	neverSample := WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.NeverSample())))
+	tests := []struct {
+		name   string
+		opts   []Option
+		sql    string
+		parent bool
+		want   []string
+	}{
+		{"filtered", []Option{rejectPing}, "SELECT 1", false, []string{`otelxorm: "SELECT 1" filtered out, no span started`}},
+		{"root span", []Option{rejectPing}, "SELECT * FROM users", false, []string{
+			`otelxorm: no parent span for "SELECT * FROM users", the span is a root span`,
+			`otelxorm: span "xorm-db" started for "SELECT * FROM users", recording: true`,
+		}},
+		{"child span", nil, "SELECT * FROM users", true, []string{
+			`otelxorm: parent span %s found for "SELECT * FROM users"`,
+			`otelxorm: span "xorm-db" started for "SELECT * FROM users", recording: true`,
+		}},
+		{"not recording", []Option{neverSample}, "SELECT * FROM users", false, []string{
+			`otelxorm: no parent span for "SELECT * FROM users", the span is a root span`,
+			`otelxorm: span "xorm-db" started for "SELECT * FROM users", recording: false`,
+			`otelxorm: span for "SELECT * FROM users" not recording, no attributes set`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lines []string
+			logger := WithDebugLogger(func(format string, args ...interface{}) {
+				lines = append(lines, fmt.Sprintf(format, args...))
+			})
+			h, _ := newTestHook(append(tt.opts, logger)...)
+			ctx := context.Background()
+			want := tt.want
+			if tt.parent {
+				var parent trace.Span
+				ctx, parent = h.config.tracerProvider.Tracer("test").Start(ctx, "parent")
+				defer parent.End()
+				want = append([]string{fmt.Sprintf(want[0], parent.SpanContext().SpanID())}, want[1:]...)
+			}
+			testQuery{ctx: ctx, sql: tt.sql}.run(t, h)
+			if !reflect.DeepEqual(lines, want) {
+				t.Errorf("debug output = %q, want %q", lines, want)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	var gotArgs []interface{}
+	rejectPing := WithFilter(func(sql string, args []interface{}) bool {
+		gotArgs = args
+		return sql != "SELECT 1"
+	})
+	tests := []struct {
+		name string
+		sql  string
+		args []interface{}
+		// lateSQL leaves the SQL out of BeforeProcess, as xorm does for
+		// some calls, so that the filter runs in AfterProcess.
+		lateSQL   bool
+		wantSpans int
+	}{
+		{"rejected", "SELECT 1", nil, false, 0},
+		{"accepted", "SELECT * FROM users WHERE id = ?", []interface{}{7}, false, 1},
+		{"rejected in AfterProcess", "SELECT 1", nil, true, 0},
+		{"accepted in AfterProcess", "SELECT * FROM users WHERE id = ?", []interface{}{7}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotArgs = nil
+			h, exp := newTestHook(rejectPing)
+			c := contexts.NewContextHook(context.Background(), tt.sql, tt.args)
+			if tt.lateSQL {
+				c.SQL, c.Args = "", nil
+			}
+			ctx, err := h.BeforeProcess(c)
+			if err != nil {
+				t.Fatalf("BeforeProcess: %v", err)
+			}
+			// The span starts in BeforeProcess only if the query is
+			// accepted there.
+			started := trace.SpanFromContext(ctx).SpanContext().IsValid()
+			if want := !tt.lateSQL && tt.wantSpans == 1; started != want {
+				t.Errorf("span started in BeforeProcess %v, want %v", started, want)
+			}
+			c.SQL, c.Args = tt.sql, tt.args
+			c.End(ctx, nil, nil)
+			if err := h.AfterProcess(c); err != nil {
+				t.Fatalf("AfterProcess: %v", err)
+			}
+
+			if spans := exp.GetSpans(); len(spans) != tt.wantSpans {
+				t.Fatalf("got %d spans, want %d", len(spans), tt.wantSpans)
+			}
+			if !reflect.DeepEqual(gotArgs, tt.args) {
+				t.Errorf("filter args = %v, want %v", gotArgs, tt.args)
+			}
+		})
+	}
+}